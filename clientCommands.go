@@ -0,0 +1,361 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"tcp_sandbox/domain"
+	"tcp_sandbox/service"
+)
+
+// -----------------------------------------------------------
+// Admin API Client (tenants) & Test Client (send)
+// -----------------------------------------------------------
+
+// runTenants manages the tenants of a running server through its admin API.
+//
+//	tcp_sandbox tenants [-admin http://localhost:8080] list [-json]
+//	tcp_sandbox tenants add -id tenant-a -listen :3000 [-listen ...] [-name ...] [-endpoint URL] ...
+//	tcp_sandbox tenants remove <id>
+//	tcp_sandbox tenants patch <id> '{"Comment": "..."}'    (reads the JSON from stdin if omitted)
+func runTenants(args []string) error {
+	fs := flag.NewFlagSet("tenants", flag.ExitOnError)
+	admin := fs.String("admin", adminURL(envOr(adminAddrEnv, defaultAdminAddr)), "admin API URL or host:port (env "+adminAddrEnv+")")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: tcp_sandbox tenants [-admin URL] list|add|remove|patch [flags]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return fmt.Errorf("missing tenants command")
+	}
+
+	client := &adminClient{base: adminURL(*admin)}
+	cmd, rest := fs.Arg(0), fs.Args()[1:]
+	switch cmd {
+	case "list":
+		return tenantsList(client, rest)
+	case "add":
+		return tenantsAdd(client, rest)
+	case "remove":
+		return tenantsRemove(client, rest)
+	case "patch":
+		return tenantsPatch(client, rest)
+	default:
+		return fmt.Errorf("unknown tenants command %q (list, add, remove or patch)", cmd)
+	}
+}
+
+func tenantsList(client *adminClient, args []string) error {
+	fs := flag.NewFlagSet("tenants list", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "print the full status as JSON")
+	fs.Parse(args)
+
+	if *asJSON {
+		body, err := client.do(http.MethodGet, "/status", nil)
+		if err != nil {
+			return err
+		}
+		_, err = os.Stdout.Write(body)
+		return err
+	}
+	statuses, err := client.statuses()
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tNAME\tLISTENERS\tCONNECTIONS\tMESSAGES\tERRORS")
+	for _, st := range statuses {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%d\t%d\n",
+			st.ID, st.Name, strings.Join(st.ListenAddresses, ","), st.Connections, st.Messages, st.Errors)
+	}
+	return tw.Flush()
+}
+
+func tenantsAdd(client *adminClient, args []string) error {
+	fs := flag.NewFlagSet("tenants add", flag.ExitOnError)
+	id := fs.String("id", "", "tenant ID (required)")
+	name := fs.String("name", "", "tenant name (default: the ID)")
+	var listen stringList
+	fs.Var(&listen, "listen", "listen address host:port, repeatable (required)")
+	start := fs.Uint("start", 2, "start byte of frames")
+	end := fs.Uint("end", 3, "end byte of frames")
+	endpoint := fs.String("endpoint", "", "upstream URL received messages are posted to")
+	format := fs.String("format", "", "upstream message format: json, xml or text")
+	auth := fs.String("auth", "none", "upstream auth type")
+	comment := fs.String("comment", "", "comment")
+	fs.Parse(args)
+
+	if *id == "" || len(listen) == 0 {
+		return fmt.Errorf("-id and -listen are required")
+	}
+	if *start > 255 || *end > 255 {
+		return fmt.Errorf("-start and -end must be bytes (0-255)")
+	}
+	if *name == "" {
+		*name = *id
+	}
+	if _, found, err := client.tenant(*id); err != nil {
+		return err
+	} else if found {
+		return fmt.Errorf("tenant %q already exists (use tenants patch)", *id)
+	}
+
+	cfg := domain.TenantConfig{
+		ID:            *id,
+		Name:          *name,
+		Comment:       *comment,
+		StartByte:     byte(*start),
+		EndByte:       byte(*end),
+		AuthType:      *auth,
+		MessageFormat: *format,
+		Endpoint:      *endpoint,
+	}
+	for _, addr := range listen {
+		cfg.Listeners = append(cfg.Listeners, domain.ListenerConfig{Address: addr})
+	}
+	body, err := json.Marshal([]domain.TenantConfig{cfg})
+	if err != nil {
+		return err
+	}
+	if err := client.patch(http.MethodPost, body); err != nil {
+		return err
+	}
+	fmt.Printf("Added tenant %q\n", *id)
+	return nil
+}
+
+func tenantsRemove(client *adminClient, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: tcp_sandbox tenants remove <id>")
+	}
+	id := args[0]
+	if err := client.mustExist(id); err != nil {
+		return err
+	}
+	body, _ := json.Marshal([]domain.TenantPatch{{TenantConfig: domain.TenantConfig{ID: id}, Remove: true}})
+	if err := client.patch(http.MethodPatch, body); err != nil {
+		return err
+	}
+	fmt.Printf("Removed tenant %q\n", id)
+	return nil
+}
+
+func tenantsPatch(client *adminClient, args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return fmt.Errorf("usage: tcp_sandbox tenants patch <id> [json]")
+	}
+	id := args[0]
+	var data []byte
+	if len(args) == 2 {
+		data = []byte(args[1])
+	} else {
+		var err error
+		if data, err = io.ReadAll(os.Stdin); err != nil {
+			return fmt.Errorf("reading patch from stdin: %w", err)
+		}
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return fmt.Errorf("patch must be a JSON object: %w", err)
+	}
+	fields["ID"] = id
+
+	if err := client.mustExist(id); err != nil {
+		return err
+	}
+	body, _ := json.Marshal(fields)
+	if err := client.patch(http.MethodPatch, body); err != nil {
+		return err
+	}
+	fmt.Printf("Patched tenant %q\n", id)
+	return nil
+}
+
+// adminClient talks to the admin API of a running server.
+type adminClient struct {
+	base string
+}
+
+// do sends a request and returns the response body, also along with the error for a status
+// other than 2xx.
+func (c *adminClient) do(method, path string, body []byte) ([]byte, error) {
+	req, err := http.NewRequest(method, c.base+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := (&http.Client{Timeout: 10 * time.Second}).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return data, fmt.Errorf("%s %s: status %d: %s", method, path, resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+	return data, nil
+}
+
+// patch sends patch entries and prints the validation warnings of the answer, or the errors
+// the patch was rejected for.
+func (c *adminClient) patch(method string, body []byte) error {
+	data, err := c.do(method, "/patch", body)
+	if err != nil {
+		var report domain.ValidationReport
+		if json.Unmarshal(data, &report) != nil || len(report.Errors) == 0 {
+			return err
+		}
+		for _, is := range report.Errors {
+			fmt.Println("ERROR  ", service.FormatIssue(is))
+		}
+		return fmt.Errorf("patch rejected with %d error(s)", len(report.Errors))
+	}
+	var result struct {
+		Warnings []domain.ValidationIssue `json:"warnings"`
+	}
+	_ = json.Unmarshal(data, &result)
+	for _, is := range result.Warnings {
+		fmt.Println("WARNING", service.FormatIssue(is))
+	}
+	return nil
+}
+
+func (c *adminClient) statuses() ([]domain.TenantStatus, error) {
+	data, err := c.do(http.MethodGet, "/status", nil)
+	if err != nil {
+		return nil, err
+	}
+	var statuses []domain.TenantStatus
+	if err := json.Unmarshal(data, &statuses); err != nil {
+		return nil, fmt.Errorf("decoding status: %w", err)
+	}
+	return statuses, nil
+}
+
+func (c *adminClient) tenant(id string) (domain.TenantStatus, bool, error) {
+	statuses, err := c.statuses()
+	if err != nil {
+		return domain.TenantStatus{}, false, err
+	}
+	for _, st := range statuses {
+		if st.ID == id {
+			return st, true, nil
+		}
+	}
+	return domain.TenantStatus{}, false, nil
+}
+
+func (c *adminClient) mustExist(id string) error {
+	_, found, err := c.tenant(id)
+	if err == nil && !found {
+		err = fmt.Errorf("no tenant %q", id)
+	}
+	return err
+}
+
+// adminURL turns an admin listen address like ":8080" into a URL to reach it.
+func adminURL(addr string) string {
+	if strings.Contains(addr, "://") {
+		return strings.TrimRight(addr, "/")
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "http://" + addr
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "localhost"
+	}
+	return "http://" + net.JoinHostPort(host, port)
+}
+
+// stringList collects a repeatable flag.
+type stringList []string
+
+func (l *stringList) String() string     { return strings.Join(*l, ",") }
+func (l *stringList) Set(v string) error { *l = append(*l, v); return nil }
+
+// runSend sends framed messages to a tenant listener and prints what comes back until the
+// connection has been quiet for -wait.
+//
+//	tcp_sandbox send [-start 2] [-end 3] [-tls [-insecure]] [-wait 2s] host:port message...
+func runSend(args []string) error {
+	fs := flag.NewFlagSet("send", flag.ExitOnError)
+	start := fs.Uint("start", 2, "start byte of frames")
+	end := fs.Uint("end", 3, "end byte of frames")
+	useTLS := fs.Bool("tls", false, "connect with TLS")
+	insecure := fs.Bool("insecure", false, "with -tls: do not verify the server certificate")
+	wait := fs.Duration("wait", 2*time.Second, "how long to wait for more data after the last byte received")
+	fs.Parse(args)
+
+	if fs.NArg() < 2 {
+		return fmt.Errorf("usage: tcp_sandbox send [flags] host:port message...")
+	}
+	if *start > 255 || *end > 255 {
+		return fmt.Errorf("-start and -end must be bytes (0-255)")
+	}
+	addr := fs.Arg(0)
+
+	var conn net.Conn
+	var err error
+	dialer := &net.Dialer{Timeout: 5 * time.Second}
+	if *useTLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, &tls.Config{InsecureSkipVerify: *insecure})
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	for _, msg := range fs.Args()[1:] {
+		frame := append(append([]byte{byte(*start)}, msg...), byte(*end))
+		if _, err := conn.Write(frame); err != nil {
+			return err
+		}
+		fmt.Printf("> %s\n", msg)
+	}
+
+	reader := bufio.NewReader(conn)
+	var buffer []byte
+	inFrame := false
+	for {
+		conn.SetReadDeadline(time.Now().Add(*wait))
+		b, err := reader.ReadByte()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() || err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		switch {
+		case b == byte(*start):
+			buffer, inFrame = buffer[:0], true
+		case b == byte(*end) && inFrame:
+			fmt.Printf("< %s\n", buffer)
+			inFrame = false
+		case inFrame:
+			buffer = append(buffer, b)
+		default:
+			fmt.Printf("< (unframed) %q\n", b)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"tcp_sandbox/service"
+)
+
+// runEncrypt prints the encrypted form of a value for use in tenants.json.
+//
+//	tcp_sandbox encrypt [-key-file path] <value>    (reads the value from stdin if omitted)
+func runEncrypt(args []string) error {
+	fs := flag.NewFlagSet("encrypt", flag.ExitOnError)
+	keyFile := fs.String("key-file", "", "master key file (default: $"+service.MasterKeyEnv+" or $"+service.MasterKeyFileEnv+")")
+	fs.Parse(args)
+
+	key, err := masterKeyFrom(*keyFile)
+	if err != nil {
+		return err
+	}
+
+	var value string
+	if fs.NArg() > 0 {
+		value = fs.Arg(0)
+	} else {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return fmt.Errorf("reading value from stdin: %w", err)
+		}
+		value = strings.TrimRight(line, "\r\n")
+	}
+
+	enc, err := service.EncryptSecret(key, value)
+	if err != nil {
+		return err
+	}
+	fmt.Println(enc)
+	return nil
+}
+
+// runRotateKey re-encrypts all encrypted values of the tenants file and its versions with a new master key.
+//
+//	tcp_sandbox rotate-key -new-key-file path [-key-file path] [-file tenants.json]
+func runRotateKey(args []string) error {
+	fs := flag.NewFlagSet("rotate-key", flag.ExitOnError)
+	keyFile := fs.String("key-file", "", "current master key file (default: $"+service.MasterKeyEnv+" or $"+service.MasterKeyFileEnv+")")
+	newKeyFile := fs.String("new-key-file", "", "new master key file (required)")
+	file := fs.String("file", envOr(configEnv, defaultConfigFile), "tenants file to rewrite (env "+configEnv+")")
+	fs.Parse(args)
+
+	if *newKeyFile == "" {
+		return fmt.Errorf("-new-key-file is required")
+	}
+	oldKey, err := masterKeyFrom(*keyFile)
+	if err != nil {
+		return err
+	}
+	newKey, err := service.LoadMasterKeyFile(*newKeyFile)
+	if err != nil {
+		return err
+	}
+
+	n, err := service.RotateMasterKey(*file, oldKey, newKey)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Re-encrypted %d value(s) in %s and its kept versions\n", n, *file)
+	return nil
+}
+
+// runValidate checks a tenants file and prints its errors and warnings. It fails if the file has errors.
+//
+//	tcp_sandbox validate [-file tenants.json] [-json]
+func runValidate(args []string) error {
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	file := fs.String("file", envOr(configEnv, defaultConfigFile), "tenants file to check (env "+configEnv+")")
+	asJSON := fs.Bool("json", false, "print the report as JSON")
+	fs.Parse(args)
+
+	data, err := os.ReadFile(*file)
+	if err != nil {
+		return err
+	}
+	report := service.ValidateTenantsConfig(data)
+
+	if *asJSON {
+		out, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(out))
+	} else {
+		for _, is := range report.Errors {
+			fmt.Println("ERROR  ", service.FormatIssue(is))
+		}
+		for _, is := range report.Warnings {
+			fmt.Println("WARNING", service.FormatIssue(is))
+		}
+		fmt.Printf("%s: %d error(s), %d warning(s)\n", *file, len(report.Errors), len(report.Warnings))
+	}
+	if !report.Valid {
+		return fmt.Errorf("%s is invalid", *file)
+	}
+	return nil
+}
+
+// masterKeyFrom loads the master key from an explicit file, or from the environment.
+func masterKeyFrom(keyFile string) ([]byte, error) {
+	if keyFile != "" {
+		return service.LoadMasterKeyFile(keyFile)
+	}
+	key, err := service.LoadMasterKey()
+	if err != nil {
+		return nil, err
+	}
+	if key == nil {
+		return nil, fmt.Errorf("no master key: use -key-file or set %s / %s", service.MasterKeyEnv, service.MasterKeyFileEnv)
+	}
+	return key, nil
+}
@@ -0,0 +1,94 @@
+package controller
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"tcp_sandbox/service"
+)
+
+// handleConfigHistory returns the latest config changes (file reloads and patches), newest first.
+// The optional "limit" query parameter caps the number of entries.
+//
+// Example:
+//
+//	curl http://localhost:8080/config/history?limit=5
+func handleConfigHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	history := service.ConfigHistory()
+	if s := r.URL.Query().Get("limit"); s != "" {
+		limit, err := strconv.Atoi(s)
+		if err != nil || limit < 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		if limit < len(history) {
+			history = history[:limit]
+		}
+	}
+	writeJSON(w, http.StatusOK, history)
+}
+
+// handleConfigVersions lists the kept versions of the tenants file, shows one (plain secrets
+// redacted) or rolls back to one. A rollback is applied live like a file reload and answers
+// 422 with the validation report if the version is no longer valid.
+//
+// Examples:
+//
+//	curl http://localhost:8080/config/versions
+//	curl http://localhost:8080/config/versions/20261018T182703.123456789Z
+//	curl -X POST http://localhost:8080/config/versions/20261018T182703.123456789Z/rollback
+func handleConfigVersions(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/config/versions"), "/")
+	parts := strings.Split(path, "/")
+
+	switch {
+	case path == "" && r.Method == http.MethodGet:
+		versions, err := service.ListConfigVersions(tenantsFile)
+		if err != nil {
+			log.Printf("[ERROR] Listing config versions failed: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, versions)
+
+	case len(parts) == 1 && path != "" && r.Method == http.MethodGet:
+		tenants, err := service.ConfigVersionTenants(tenantsFile, parts[0])
+		if errors.Is(err, service.ErrVersionNotFound) {
+			http.Error(w, "Version not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Printf("[ERROR] Reading config version %s failed: %v", parts[0], err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, tenants)
+
+	case len(parts) == 2 && parts[1] == "rollback" && r.Method == http.MethodPost:
+		err := service.RollbackConfig(tenantsFile, parts[0])
+		var cfgErr *service.ConfigError
+		switch {
+		case err == nil:
+			writeJSON(w, http.StatusOK, map[string]string{"status": "ok", "version": parts[0]})
+		case errors.Is(err, service.ErrVersionNotFound):
+			http.Error(w, "Version not found", http.StatusNotFound)
+		case errors.As(err, &cfgErr):
+			writeJSON(w, http.StatusUnprocessableEntity, cfgErr.Report)
+		default:
+			log.Printf("[ERROR] Rollback to config version %s failed: %v", parts[0], err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		}
+
+	case len(parts) <= 2:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	default:
+		http.NotFound(w, r)
+	}
+}
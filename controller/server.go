@@ -2,43 +2,112 @@ package controller
 
 import (
 	// ...
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
+	"os"
+	"sort"
+	"sync"
 
 	"tcp_sandbox/domain"
 	"tcp_sandbox/globals"
 	"tcp_sandbox/service"
 )
 
-// startRESTServer
-func StartRESTServer() {
+// tenantsFile is the tenants file patches are saved to and versions are kept for.
+var tenantsFile = "tenants.json"
+
+var (
+	adminServer     *http.Server
+	adminListener   net.Listener
+	adminServerLock sync.Mutex
+)
+
+// StartRESTServer starts serving the admin API on addr (e.g. ":8080") in the background,
+// on the socket inherited from the previous process if there is one; filename is the
+// tenants file.
+func StartRESTServer(addr, filename string) error {
+	ln := service.InheritedListener(addr)
+	if ln == nil {
+		var err error
+		if ln, err = net.Listen("tcp", addr); err != nil {
+			return err
+		}
+	}
+	tenantsFile = filename
+	adminServerLock.Lock()
+	adminServer = &http.Server{Addr: addr}
+	adminListener = ln
+	srv := adminServer
+	adminServerLock.Unlock()
+
 	http.HandleFunc("/patch", handlePatchTenants)
-	log.Printf("REST server listening on :8080")
-	err := http.ListenAndServe(":8080", nil)
-	if err != nil {
-		log.Fatalf("HTTP server failed: %v", err)
+	http.HandleFunc("/tokens", handleTokens)
+	http.HandleFunc("/status", handleStatus)
+	http.HandleFunc("/tenants/validate", handleValidateTenants)
+	http.HandleFunc("/config/history", handleConfigHistory)
+	http.HandleFunc("/config/versions", handleConfigVersions)
+	http.HandleFunc("/config/versions/", handleConfigVersions)
+	log.Printf("REST server listening on %s", addr)
+	go func() {
+		service.WaitAccepting()
+		err := srv.Serve(ln)
+		if err != nil && err != http.ErrServerClosed {
+			log.Fatalf("[ERROR] HTTP server failed: %v", err)
+		}
+	}()
+	return nil
+}
+
+// AdminListenerFile returns the admin API's address and a copy of its socket, to pass to a
+// restarted process.
+func AdminListenerFile() (string, *os.File, error) {
+	adminServerLock.Lock()
+	defer adminServerLock.Unlock()
+	if adminListener == nil {
+		return "", nil, errors.New("admin API is not listening")
+	}
+	f, err := service.ListenerFile(adminListener)
+	return adminServer.Addr, f, err
+}
+
+// StopRESTServer stops the admin API, letting running requests finish until ctx is done.
+func StopRESTServer(ctx context.Context) error {
+	adminServerLock.Lock()
+	srv := adminServer
+	adminServerLock.Unlock()
+	if srv == nil {
+		return nil
 	}
+	return srv.Shutdown(ctx)
 }
 
 // handlePatchTenants expects a JSON array or single object describing partial Tenant updates.
+// Tenants are identified by "ID" (older clients may send "Port" instead).
 // If "Remove" is true in the incoming data for a Tenant, that tenant is removed from the system.
+// The tenants as patched are validated like the tenants file: a patch leaving a tenant invalid is
+// rejected as a whole with 400 and the validation report; warnings about the tenants it touched
+// are returned with the OK answer.
 //
-// Example PATCH/POST body for removing a tenant on port 4000:
+// Example PATCH/POST body for removing the tenant "tenantb":
 //
 //  [
 //    {
-//      "Port": "4000",
+//      "ID": "tenantb",
 //      "Remove": true
 //    }
 //  ]
 //
-// Example for patching a tenant on port 3000:
+// Example for patching the tenant "tenanta":
 //
 //  [
 //    {
-//      "Port": "3000",
+//      "ID": "tenanta",
 //      "Name": "NewTenantA",
 //      "Comment": "Updated comment...",
 //      "KeepAliveIntervalSec": 60
@@ -54,107 +123,329 @@ func handlePatchTenants(w http.ResponseWriter, r *http.Request) {
 	// Read request body
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		log.Printf("Error reading body: %v", err)
+		log.Printf("[ERROR] Error reading body: %v", err)
 		http.Error(w, "Bad request", http.StatusBadRequest)
 		return
 	}
 	defer r.Body.Close()
 
-	var patchTenants []domain.Tenant
-	if err := json.Unmarshal(body, &patchTenants); err != nil {
+	var patches []*domain.TenantPatch
+	if err := json.Unmarshal(body, &patches); err != nil {
 		// single tenant
-		var single domain.Tenant
-		if err2 := json.Unmarshal(body, &single); err2 != nil {
-			log.Printf("Invalid patch body: %v", err2)
+		single := &domain.TenantPatch{}
+		if err2 := json.Unmarshal(body, single); err2 != nil {
+			log.Printf("[ERROR] Invalid patch body: %v", err2)
 			http.Error(w, "Invalid JSON", http.StatusBadRequest)
 			return
 		}
-		patchTenants = append(patchTenants, single)
+		patches = append(patches, single)
 	}
 
-	globals.TenantsLock.Lock()
-	defer globals.TenantsLock.Unlock()
-
-	for i := range patchTenants {
-		pt := &patchTenants[i]
+	patchTenants := make([]*domain.Tenant, len(patches))
+	for i, p := range patches {
+		pt := &domain.Tenant{TenantConfig: p.TenantConfig}
+		if p.AuthRetryOn403 != nil {
+			pt.AuthRetryOn403 = *p.AuthRetryOn403
+		}
+		if p.KeepAliveWriteBack != nil {
+			pt.KeepAliveWriteBack = *p.KeepAliveWriteBack
+		}
+		if err := service.ResolveSecretRefs(pt); err != nil {
+			log.Printf("[ERROR] Invalid patch body: %v", err)
+			http.Error(w, "Invalid secret reference", http.StatusBadRequest)
+			return
+		}
+		patchTenants[i] = pt
+	}
 
-		if pt.Port == "" {
-			// Port is our primary key
-			log.Printf("Patch data missing 'Port' field; skipping entry: %+v", pt)
+	globals.TenantsLock.Lock()
+	// The patch is applied to copies of the tenants first and only takes effect if they validate.
+	planned := service.CloneTenants()
+	touched := make(map[string]bool)
+	var changes []string
+	for i, pt := range patchTenants {
+		if pt.ID == "" && pt.Port == "" {
+			// ID is our primary key
+			log.Printf("Patch data missing 'ID' field; skipping entry: %+v", service.RedactedTenant(pt).TenantConfig)
 			continue
 		}
 
-		if pt.Remove {
-			// remove this tenant
-			_, ok := globals.Tenants[pt.Port]
+		existing, ok := planned[pt.ID]
+		if pt.ID == "" {
+			// older clients identify tenants by port
+			existing = service.TenantByPort(planned, pt.Port)
+			ok = existing != nil
+		}
+
+		switch {
+		case patches[i].Remove:
 			if !ok {
-				log.Printf("Tenant on port %s not found; can't remove. Skipping.", pt.Port)
+				log.Printf("Tenant %s not found; can't remove. Skipping.", patchKey(pt))
 				continue
 			}
-			// Stop listener and remove from map
-			service.StopTenantListener(pt.Port)
-			delete(globals.Tenants, pt.Port)
-			log.Printf("Removed tenant on port %s via PATCH request.", pt.Port)
-		} else {
-			existing, ok := globals.Tenants[pt.Port]
-			if !ok {
-				// Create a new tenant if not found
-				globals.Tenants[pt.Port] = pt
-				log.Printf("Created a new tenant on port %s (via PATCH).", pt.Port)
-			} else {
-				// Patch existing
-				if pt.Name != "" {
-					existing.Name = pt.Name
-				}
-				if pt.Comment != "" {
-					existing.Comment = pt.Comment
-				}
-				if pt.StartByte != 0 {
-					existing.StartByte = pt.StartByte
-				}
-				if pt.EndByte != 0 {
-					existing.EndByte = pt.EndByte
-				}
-				if pt.SimpleAuthToken != "" {
-					existing.SimpleAuthToken = pt.SimpleAuthToken
-				}
-
-				// OAuth credentials
-				if pt.OAuthCredentials.ClientID != "" {
-					existing.OAuthCredentials.ClientID = pt.OAuthCredentials.ClientID
-				}
-				if pt.OAuthCredentials.ClientSecret != "" {
-					existing.OAuthCredentials.ClientSecret = pt.OAuthCredentials.ClientSecret
-				}
-				if pt.OAuthCredentials.TokenURL != "" {
-					existing.OAuthCredentials.TokenURL = pt.OAuthCredentials.TokenURL
-				}
-				if len(pt.OAuthCredentials.Scopes) > 0 {
-					existing.OAuthCredentials.Scopes = pt.OAuthCredentials.Scopes
-				}
-
-				// Keep-alive fields
-				if pt.KeepAliveIntervalSec != 0 {
-					existing.KeepAliveIntervalSec = pt.KeepAliveIntervalSec
-				}
-				if pt.KeepAliveFile != "" {
-					existing.KeepAliveFile = pt.KeepAliveFile
-				}
-				log.Printf("Patched tenant on port %s: %+v", pt.Port, pt)
+			delete(planned, existing.ID)
+			touched[existing.ID] = true
+			changes = append(changes, fmt.Sprintf("Removed tenant %s via PATCH request.", existing.ID))
+		case !ok:
+			// Create a new tenant if not found
+			service.MigrateTenant(pt, planned)
+			planned[pt.ID] = pt
+			touched[pt.ID] = true
+			changes = append(changes, fmt.Sprintf("Created a new tenant %s (via PATCH).", pt.ID))
+		default:
+			applyPatch(existing, pt, patches[i])
+			touched[existing.ID] = true
+			changes = append(changes, fmt.Sprintf("Patched tenant %s: %+v", existing.ID, service.RedactedTenant(pt).TenantConfig))
+		}
+	}
+
+	report := service.ValidateTenants(sortedByID(planned))
+	var warnings []domain.ValidationIssue
+	for _, is := range report.Warnings {
+		if touched[is.ID] {
+			warnings = append(warnings, is)
+		}
+	}
+	if !report.Valid {
+		globals.TenantsLock.Unlock()
+		log.Printf("[ERROR] Rejected patch, keeping the running config:")
+		for _, is := range report.Errors {
+			log.Printf("[ERROR]   %s", service.FormatIssue(is))
+		}
+		report.Warnings = warnings
+		writeJSON(w, http.StatusBadRequest, report)
+		return
+	}
+	for _, is := range warnings {
+		log.Printf("[WARN] Patch: %s", service.FormatIssue(is))
+	}
+
+	before := service.SnapshotTenants()
+	for id := range touched {
+		t, keep := planned[id]
+		existing, ok := globals.Tenants[id]
+		switch {
+		case !keep:
+			if ok {
+				service.StopTenantListeners(existing)
+				delete(globals.Tenants, id)
 			}
+		case !ok:
+			globals.Tenants[id] = t
+		default:
+			// keep its runtime state (counters, connections)
+			existing.TenantConfig = t.TenantConfig
+			existing.SecretRefs = t.SecretRefs
 		}
 	}
+	for _, c := range changes {
+		log.Print(c)
+	}
 
 	// Re-sync listeners to handle newly created or re-added tenants
 	service.SyncListeners()
+	after := service.SnapshotTenants()
+	globals.TenantsLock.Unlock()
+	service.RecordConfigChange(service.SourcePatch, before, after)
 
 	// Save updated tenants to file
-	if err := service.SaveTenantsToFile("tenants.json"); err != nil {
-		log.Printf("Failed to save tenants after patch: %v", err)
+	if err := service.SaveTenantsToFile(tenantsFile); err != nil {
+		log.Printf("[ERROR] Failed to save tenants after patch: %v", err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte(`{"status":"ok"}`))
+	writeJSON(w, http.StatusOK, patchResult{Status: "ok", Warnings: warnings})
+}
+
+// patchResult answers a successful patch, with the validation warnings of the tenants it touched.
+type patchResult struct {
+	Status   string                   `json:"status"`
+	Warnings []domain.ValidationIssue `json:"warnings,omitempty"`
+}
+
+// applyPatch sets the fields set in the patch entry pt (decoded from p) on existing.
+func applyPatch(existing, pt *domain.Tenant, p *domain.TenantPatch) {
+	if pt.Name != "" {
+		existing.Name = pt.Name
+	}
+	if pt.Comment != "" {
+		existing.Comment = pt.Comment
+	}
+	if pt.StartByte != 0 {
+		existing.StartByte = pt.StartByte
+	}
+	if pt.EndByte != 0 {
+		existing.EndByte = pt.EndByte
+	}
+	if pt.Listeners != nil {
+		existing.Listeners = pt.Listeners
+	}
+	if pt.ListenerOverlapSec != 0 {
+		existing.ListenerOverlapSec = pt.ListenerOverlapSec
+	}
+	if pt.FramingChange != "" {
+		existing.FramingChange = pt.FramingChange
+	}
+	if pt.AuthType != "" {
+		existing.AuthType = pt.AuthType
+	}
+	if pt.SimpleAuthToken != "" {
+		existing.SimpleAuthToken = pt.SimpleAuthToken
+	}
+	if pt.BasicAuth != nil {
+		existing.BasicAuth = pt.BasicAuth
+	}
+	if pt.APIKey != nil {
+		existing.APIKey = pt.APIKey
+	}
+	if pt.BearerToken != "" {
+		existing.BearerToken = pt.BearerToken
+	}
+	if pt.ExtraHeaders != nil {
+		existing.ExtraHeaders = pt.ExtraHeaders
+	}
+
+	// OAuth credentials
+	if pt.OAuthCredentials.ClientID != "" {
+		existing.OAuthCredentials.ClientID = pt.OAuthCredentials.ClientID
+	}
+	if pt.OAuthCredentials.ClientSecret != "" {
+		existing.OAuthCredentials.ClientSecret = pt.OAuthCredentials.ClientSecret
+	}
+	if pt.OAuthCredentials.TokenURL != "" {
+		existing.OAuthCredentials.TokenURL = pt.OAuthCredentials.TokenURL
+	}
+	if len(pt.OAuthCredentials.Scopes) > 0 {
+		existing.OAuthCredentials.Scopes = pt.OAuthCredentials.Scopes
+	}
+	if pt.OAuthCredentials.AuthMethod != "" {
+		existing.OAuthCredentials.AuthMethod = pt.OAuthCredentials.AuthMethod
+	}
+	if pt.OAuthCredentials.GrantType != "" {
+		existing.OAuthCredentials.GrantType = pt.OAuthCredentials.GrantType
+	}
+	if pt.OAuthCredentials.Audience != "" {
+		existing.OAuthCredentials.Audience = pt.OAuthCredentials.Audience
+	}
+	if pt.OAuthCredentials.Resource != "" {
+		existing.OAuthCredentials.Resource = pt.OAuthCredentials.Resource
+	}
+	if pt.OAuthCredentials.Username != "" {
+		existing.OAuthCredentials.Username = pt.OAuthCredentials.Username
+	}
+	if pt.OAuthCredentials.Password != "" {
+		existing.OAuthCredentials.Password = pt.OAuthCredentials.Password
+	}
+	if pt.OAuthCredentials.RefreshToken != "" {
+		existing.OAuthCredentials.RefreshToken = pt.OAuthCredentials.RefreshToken
+	}
+	if pt.OAuthCredentials.PrivateKeyFile != "" {
+		existing.OAuthCredentials.PrivateKeyFile = pt.OAuthCredentials.PrivateKeyFile
+	}
+	if pt.OAuthCredentials.KeyID != "" {
+		existing.OAuthCredentials.KeyID = pt.OAuthCredentials.KeyID
+	}
+	if pt.OAuthCredentials.SigningAlgorithm != "" {
+		existing.OAuthCredentials.SigningAlgorithm = pt.OAuthCredentials.SigningAlgorithm
+	}
+	if p.AuthRetryOn403 != nil {
+		existing.AuthRetryOn403 = *p.AuthRetryOn403
+	}
+
+	// Keep-alive fields
+	if pt.KeepAliveIntervalSec != 0 {
+		existing.KeepAliveIntervalSec = pt.KeepAliveIntervalSec
+	}
+	if pt.KeepAliveFile != "" {
+		existing.KeepAliveFile = pt.KeepAliveFile
+	}
+	if pt.KeepAliveFormat != "" {
+		existing.KeepAliveFormat = pt.KeepAliveFormat
+	}
+	if pt.KeepAliveMessage != "" {
+		existing.KeepAliveMessage = pt.KeepAliveMessage
+	}
+	if pt.KeepAliveMode != "" {
+		existing.KeepAliveMode = pt.KeepAliveMode
+	}
+	if pt.KeepAliveJitterSec != 0 {
+		existing.KeepAliveJitterSec = pt.KeepAliveJitterSec
+	}
+	if pt.KeepAliveReplyPattern != "" {
+		existing.KeepAliveReplyPattern = pt.KeepAliveReplyPattern
+	}
+	if pt.KeepAliveReplyTimeoutSec != 0 {
+		existing.KeepAliveReplyTimeoutSec = pt.KeepAliveReplyTimeoutSec
+	}
+	if pt.KeepAliveMaxMissed != 0 {
+		existing.KeepAliveMaxMissed = pt.KeepAliveMaxMissed
+	}
+	if pt.HeartbeatMatch != "" {
+		existing.HeartbeatMatch = pt.HeartbeatMatch
+	}
+	if pt.HeartbeatPattern != "" {
+		existing.HeartbeatPattern = pt.HeartbeatPattern
+	}
+	if pt.HeartbeatReply != "" {
+		existing.HeartbeatReply = pt.HeartbeatReply
+	}
+	if pt.WriteTimeoutSec != 0 {
+		existing.WriteTimeoutSec = pt.WriteTimeoutSec
+	}
+	if pt.OutboundQueueSize != 0 {
+		existing.OutboundQueueSize = pt.OutboundQueueSize
+	}
+	if pt.GoodbyeMessage != "" {
+		existing.GoodbyeMessage = pt.GoodbyeMessage
+	}
+	if pt.DrainTimeoutSec != 0 {
+		existing.DrainTimeoutSec = pt.DrainTimeoutSec
+	}
+	if p.KeepAliveWriteBack != nil {
+		existing.KeepAliveWriteBack = *p.KeepAliveWriteBack
+	}
+	if pt.KeepAliveFields != nil {
+		existing.KeepAliveFields = pt.KeepAliveFields
+	}
+
+	// Outbound rate limit
+	if pt.RateLimitPerSec != 0 {
+		existing.RateLimitPerSec = pt.RateLimitPerSec
+	}
+	if pt.RateLimitBurst != 0 {
+		existing.RateLimitBurst = pt.RateLimitBurst
+	}
+	service.MergeSecretRefs(existing, pt)
+}
+
+// sortedByID returns the tenants ordered by ID, so validation messages come in a stable order.
+func sortedByID(tenants map[string]*domain.Tenant) []*domain.Tenant {
+	ids := make([]string, 0, len(tenants))
+	for id := range tenants {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	out := make([]*domain.Tenant, len(ids))
+	for i, id := range ids {
+		out[i] = tenants[id]
+	}
+	return out
+}
+
+// patchKey describes the tenant a patch entry refers to, for logs.
+func patchKey(pt *domain.Tenant) string {
+	if pt.ID != "" {
+		return pt.ID
+	}
+	return "on port " + pt.Port
+}
+
+// writeJSON encodes v as the response body.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(v)
 }
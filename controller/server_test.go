@@ -0,0 +1,111 @@
+package controller
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"tcp_sandbox/domain"
+	"tcp_sandbox/globals"
+	"tcp_sandbox/service"
+)
+
+// withPatchTenant runs /patch against a single tenant; patches are saved to a temporary file.
+func withPatchTenant(t *testing.T) *domain.Tenant {
+	// a free port; the validator does not accept port 0
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	tenant := &domain.Tenant{TenantConfig: domain.TenantConfig{
+		ID: "a", Name: "A", StartByte: 2, EndByte: 3, Endpoint: "http://upstream.invalid/in", AuthType: "none",
+		Listeners: []domain.ListenerConfig{{Address: addr}},
+	}}
+	globals.TenantsLock.Lock()
+	saved := globals.Tenants
+	globals.Tenants = map[string]*domain.Tenant{"a": tenant}
+	globals.TenantsLock.Unlock()
+
+	savedFile := tenantsFile
+	tenantsFile = filepath.Join(t.TempDir(), "tenants.json")
+	t.Cleanup(func() {
+		globals.TenantsLock.Lock()
+		globals.Tenants = map[string]*domain.Tenant{}
+		service.SyncListeners() // stops what the patches started
+		globals.Tenants = saved
+		globals.TenantsLock.Unlock()
+		tenantsFile = savedFile
+	})
+	return tenant
+}
+
+func patch(body string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	handlePatchTenants(rec, httptest.NewRequest(http.MethodPatch, "/patch", strings.NewReader(body)))
+	return rec
+}
+
+func TestPatchRejectsInvalidTenants(t *testing.T) {
+	tenant := withPatchTenant(t)
+
+	for _, body := range []string{
+		`{"ID":"a","StartByte":3}`,                                                   // StartByte == EndByte
+		`{"ID":"a","Comment":"x","FramingChange":"sometimes"}`,                       // unknown mode
+		`[{"ID":"a","Comment":"x"},{"ID":"b","Name":"B","StartByte":2,"EndByte":3}]`, // b has no listener
+		`{"ID":"b","Name":"B","StartByte":2,"EndByte":3,"Listeners":[{"Address":"` + tenant.Listeners[0].Address + `"}],"Endpoint":"http://x/"}`, // same address as a
+	} {
+		rec := patch(body)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", body, rec.Code)
+			continue
+		}
+		var report domain.ValidationReport
+		if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil || report.Valid || len(report.Errors) == 0 {
+			t.Errorf("%s: answer is not a report with errors: %s", body, rec.Body)
+		}
+	}
+
+	if tenant.StartByte != 2 || tenant.Comment != "" || tenant.Endpoint != "http://upstream.invalid/in" {
+		t.Errorf("rejected patches changed the tenant: %+v", tenant.TenantConfig)
+	}
+	if len(globals.Tenants) != 1 {
+		t.Errorf("rejected patch created a tenant: %d tenants", len(globals.Tenants))
+	}
+	if _, err := os.Stat(tenantsFile); !os.IsNotExist(err) {
+		t.Errorf("tenants file written for rejected patches")
+	}
+}
+
+func TestPatchAppliesAndWarns(t *testing.T) {
+	tenant := withPatchTenant(t)
+
+	rec := patch(`{"ID":"a","Comment":"patched","AuthRetryOn403":true,"KeepAliveIntervalSec":30}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	var result patchResult
+	if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	if result.Status != "ok" || len(result.Warnings) != 1 || result.Warnings[0].Field != "KeepAliveIntervalSec" {
+		t.Errorf("answer %s, want ok with the KeepAliveIntervalSec warning", rec.Body)
+	}
+	if tenant.Comment != "patched" || !tenant.AuthRetryOn403 || tenant.KeepAliveIntervalSec != 30 {
+		t.Errorf("tenant not patched in place: %+v", tenant.TenantConfig)
+	}
+
+	if rec := patch(`{"ID":"a","AuthRetryOn403":false}`); rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	if tenant.AuthRetryOn403 || tenant.Comment != "patched" {
+		t.Errorf("AuthRetryOn403 not turned off, or other fields lost: %+v", tenant.TenantConfig)
+	}
+}
@@ -0,0 +1,20 @@
+package controller
+
+import (
+	"net/http"
+
+	"tcp_sandbox/service"
+)
+
+// handleStatus returns the runtime status of all tenants (connections, counters, next keep-alive).
+//
+// Example:
+//
+//	curl http://localhost:8080/status
+func handleStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, http.StatusOK, service.TenantStatuses())
+}
@@ -0,0 +1,20 @@
+package controller
+
+import (
+	"net/http"
+
+	"tcp_sandbox/service"
+)
+
+// handleTokens lists the cached OAuth tokens (redacted), which tenants share them and when they expire.
+//
+// Example:
+//
+//	curl http://localhost:8080/tokens
+func handleTokens(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, http.StatusOK, service.TokenStatuses())
+}
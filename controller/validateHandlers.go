@@ -0,0 +1,33 @@
+package controller
+
+import (
+	"io"
+	"net/http"
+
+	"tcp_sandbox/service"
+)
+
+// handleValidateTenants checks a complete tenants config (the content of tenants.json) without
+// applying it. It answers 200 with the report if the config is valid, 422 otherwise.
+//
+// Example:
+//
+//	curl -X POST --data-binary @tenants.json http://localhost:8080/tenants/validate
+func handleValidateTenants(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	report := service.ValidateTenantsConfig(body)
+	status := http.StatusOK
+	if !report.Valid {
+		status = http.StatusUnprocessableEntity
+	}
+	writeJSON(w, status, report)
+}
@@ -0,0 +1,27 @@
+package domain
+
+import "time"
+
+// ConfigDiff describes what one reload or patch changed in the running tenant config.
+type ConfigDiff struct {
+	Time     time.Time
+	Source   string       // "file", "patch" or "rollback"
+	Added    []TenantDiff `json:",omitempty"`
+	Removed  []TenantDiff `json:",omitempty"`
+	Modified []TenantDiff `json:",omitempty"`
+}
+
+// TenantDiff is one added, removed or modified tenant. Fields is only set for modified tenants.
+type TenantDiff struct {
+	ID     string
+	Name   string
+	Fields []FieldChange `json:",omitempty"`
+}
+
+// FieldChange is one changed config field, e.g. "OAuthCredentials.TokenURL".
+// Secret values are redacted; Old or New is nil if the field was unset.
+type FieldChange struct {
+	Field string
+	Old   interface{}
+	New   interface{}
+}
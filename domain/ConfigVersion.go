@@ -0,0 +1,10 @@
+package domain
+
+import "time"
+
+// ConfigVersion is a previous version of the tenants file, kept for rollback.
+type ConfigVersion struct {
+	ID   string    // e.g. "20261018T182703.123456789Z"
+	Time time.Time // when this version was replaced
+	Size int64
+}
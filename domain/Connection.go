@@ -0,0 +1,50 @@
+package domain
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Connection is one accepted client connection of a tenant (runtime only).
+type Connection struct {
+	Conn        net.Conn
+	Endpoint    string // listen address the client connected to
+	ConnectedAt time.Time
+
+	// Framing (a Framing value) this connection parses and frames with; it stays at the
+	// generation of accept time unless the tenant switches framing at frame boundaries
+	Framing atomic.Value
+
+	// UnixNano of the last byte read or written, accessed atomically
+	LastActivity int64
+	// UnixNano of the next idle keep-alive (KeepAliveMode "idle"), 0 if none; accessed atomically
+	NextKeepAlive int64
+
+	// Keep-alive replies (KeepAliveReplyPattern), accessed atomically:
+	// UnixNano of the last matching reply, consecutive and total missed replies
+	LastKeepAliveReply    int64
+	MissedKeepAlives      uint64
+	MissedKeepAlivesTotal uint64
+
+	// UnixNano of the last client heartbeat (HeartbeatMatch / HeartbeatPattern), accessed atomically
+	LastHeartbeat int64
+
+	// Frames waiting for the connection's writer goroutine, and its per-write deadline
+	Outbound     chan []byte
+	WriteTimeout time.Duration
+	// Frames queued or being written, accessed atomically
+	Pending int64
+
+	// Draining is set to 1 (atomically) when the connection is shut down: the handler stops
+	// reading at the next frame boundary, or at DrainDeadline (UnixNano), and the drain closes it
+	Draining      int32
+	DrainDeadline int64
+
+	// ReaderDone is closed when the connection handler stops reading
+	ReaderDone chan struct{}
+	// Closed is closed when the connection is closed and forgotten (once, see CloseOnce)
+	Closed    chan struct{}
+	CloseOnce sync.Once
+}
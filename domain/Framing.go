@@ -0,0 +1,19 @@
+package domain
+
+// Framing is the frame delimiters of one config generation of a tenant. The generation is
+// incremented on every config change applied to the tenant.
+type Framing struct {
+	StartByte  byte
+	EndByte    byte
+	Generation uint64
+
+	// Open connections switch to a newer generation at their next frame boundary
+	SwitchAtBoundary bool
+}
+
+// TenantFraming is the framing of one config generation of a tenant: its own delimiters and those
+// of listen addresses that override them.
+type TenantFraming struct {
+	Framing
+	Endpoints map[string]Framing `json:",omitempty"`
+}
@@ -0,0 +1,14 @@
+package domain
+
+// ListenerConfig is one endpoint a tenant accepts clients on.
+type ListenerConfig struct {
+	Address string // host:port, e.g. ":3000", "127.0.0.1:3000" or "[::1]:3000"
+
+	// Clients connect with TLS if both are set
+	TLSCertFile string `json:",omitempty"`
+	TLSKeyFile  string `json:",omitempty"`
+
+	// Framing of this endpoint; 0 uses the tenant's StartByte/EndByte
+	StartByte byte `json:",omitempty"`
+	EndByte   byte `json:",omitempty"`
+}
@@ -1,15 +1,34 @@
 package domain
 
-import "time"
-
 // OAuthCredentials holds info for acquiring or refreshing an OAuth access token.
+// The tokens themselves are runtime-only and live in the service's token manager.
 type OAuthCredentials struct {
 	ClientID     string
 	ClientSecret string
 	TokenURL     string   // e.g. "https://auth.example.com/oauth2/token"
 	Scopes       []string // e.g. ["read", "write"]
 
-	AccessToken string    // The current valid access token
-	TokenType   string    // Typically "Bearer", depends on auth server
-	TokenExpiry time.Time // When this token expires (UTC)
+	// How we authenticate at the token endpoint:
+	// "client_secret_post" (default, id/secret in the form body), "client_secret_basic" (HTTP Basic),
+	// "private_key_jwt" (signed client assertion) or "none" (public client, client_id only)
+	AuthMethod string `json:",omitempty"`
+
+	// "client_credentials" (default), "password" or "refresh_token"
+	GrantType string `json:",omitempty"`
+
+	// Optional target API parameters required by some identity providers
+	Audience string `json:",omitempty"` // sent as "audience" (e.g. Auth0)
+	Resource string `json:",omitempty"` // sent as "resource" (RFC 8707, ADFS / Azure AD v1)
+
+	// Password grant
+	Username string `json:",omitempty"`
+	Password string `json:",omitempty"`
+
+	// Initial refresh token for the refresh_token grant. Rotated refresh tokens are kept in memory only.
+	RefreshToken string `json:",omitempty"`
+
+	// private_key_jwt
+	PrivateKeyFile   string `json:",omitempty"` // PEM encoded RSA or EC private key
+	KeyID            string `json:",omitempty"` // "kid" header of the client assertion
+	SigningAlgorithm string `json:",omitempty"` // RS256 (default for RSA keys), RS384, RS512, PS256, ES256 (default for EC keys), ES384
 }
@@ -1,42 +1,14 @@
 package domain
 
-import (
-	"net"
-	"sync"
-)
-
-// Tenant holds data relevant to a particular tenant.
+// Tenant holds data relevant to a particular tenant: its config and its runtime state.
+// Only the config is serialized.
 type Tenant struct {
-	Name      string
-	Port      string
-	Comment   string
-	StartByte byte
-	EndByte   byte
-
-	// Counters
-	BytesReceived uint64
-	BytesSent     uint64
-	Errors        uint64
-
-	// SimpleAuth
-	SimpleAuthToken string
-
-	// OAuth / Credentials
-	OAuthCredentials OAuthCredentials
-
-	// Keep Alive config
-	KeepAliveIntervalSec int    // e.g. 30 -> send keep-alive every 30s
-	KeepAliveFile        string // path to the tenant's keep-alive XML file
-
-	// Connections are runtime-only; we omit them from JSON
-	Connections     []net.Conn `json:"-"`
-	ConnectionsLock sync.Mutex `json:"-"`
-
-	//Message format
-	MessageFormat string
+	// First, so the atomically updated counters are 64-bit aligned on 32-bit platforms
+	TenantState `json:"-"`
 
-	//TargetURL url
-	Endpoint string
+	TenantConfig
 
-	Remove bool `json:"remove,omitempty"`
+	// Original ${env:...} / ${file:...} references of resolved secret fields, keyed by field path
+	// (e.g. "OAuthCredentials.ClientSecret"); written back instead of the resolved values
+	SecretRefs map[string]string `json:"-"`
 }
@@ -0,0 +1,86 @@
+package domain
+
+// TenantConfig is the configuration of a tenant, as operators write it in tenants.json.
+type TenantConfig struct {
+	ID        string // primary key, stable across renames and listener changes (e.g. "tenant-a")
+	Name      string
+	Comment   string
+	StartByte byte
+	EndByte   byte
+
+	// Endpoints the tenant accepts clients on. A removed endpoint keeps accepting for
+	// ListenerOverlapSec (default 30), so clients can move over.
+	Listeners          []ListenerConfig `json:",omitempty"`
+	ListenerOverlapSec int              `json:",omitempty"`
+	// When a StartByte/EndByte change reaches open connections: "new-connections" (default,
+	// open connections keep their framing) or "next-frame" (at the next frame boundary)
+	FramingChange string `json:",omitempty"`
+
+	// Single listener of older config files (before Listeners); migrated into Listeners on load
+	Port        string `json:",omitempty"`
+	BindAddress string `json:",omitempty"`
+
+	// Upstream auth: "" keeps the legacy behaviour (SimpleAuthToken as X-Auth if set, otherwise OAuth).
+	// Explicit values: "none", "simple", "oauth", "basic", "apikey", "bearer"
+	AuthType string `json:",omitempty"`
+
+	// SimpleAuth
+	SimpleAuthToken string
+
+	// Other upstream auth schemes
+	BasicAuth    *BasicAuthCredentials `json:",omitempty"`
+	APIKey       *APIKeyCredentials    `json:",omitempty"`
+	BearerToken  string                `json:",omitempty"` // static token, sent as "Authorization: Bearer <token>"
+	ExtraHeaders map[string]string     `json:",omitempty"` // added to every upstream request
+
+	// OAuth / Credentials
+	OAuthCredentials OAuthCredentials
+	AuthRetryOn403   bool // also treat 403 from the endpoint as a rejected token
+
+	// Keep Alive config
+	KeepAliveIntervalSec int    // e.g. 30 -> send keep-alive every 30s
+	KeepAliveFile        string // path to the tenant's keep-alive XML file or template
+	KeepAliveFormat      string `json:",omitempty"` // "xml" (default), "json", "text" or "hex" (raw bytes, sent unframed)
+	KeepAliveMessage     string `json:",omitempty"` // inline keep-alive content, used instead of KeepAliveFile (e.g. "PING" or "05")
+	KeepAliveMode        string `json:",omitempty"` // "tenant" (default, all connections at the same tick) or "idle" (per connection, only after KeepAliveIntervalSec without traffic)
+	KeepAliveJitterSec   int    `json:",omitempty"` // idle mode: random extra delay of up to this many seconds per keep-alive
+	KeepAliveWriteBack   bool   `json:",omitempty"` // write updated fields back into KeepAliveFile (off by default)
+	// Optional reply expected from the client after each keep-alive (regular expression matched
+	// against the frame content, e.g. "^PONG"); matching frames are consumed, not forwarded
+	KeepAliveReplyPattern    string `json:",omitempty"`
+	KeepAliveReplyTimeoutSec int    `json:",omitempty"` // time to wait for the reply (default 10)
+	KeepAliveMaxMissed       int    `json:",omitempty"` // consecutive missed replies before the connection is closed (default 3)
+	// Fields of an XML or JSON keep-alive to update on every send: element path below the root
+	// (e.g. "header/sendTime", "device/@seq") or JSON object path -> value, which may use
+	// template variables like {{.SendTime}}. Defaults to tenantName and sendTime.
+	KeepAliveFields map[string]string `json:",omitempty"`
+
+	// Client heartbeats: frames equal to HeartbeatMatch or matching the regular expression
+	// HeartbeatPattern are answered with HeartbeatReply (the frame itself if empty) and not forwarded
+	HeartbeatMatch   string `json:",omitempty"`
+	HeartbeatPattern string `json:",omitempty"`
+	HeartbeatReply   string `json:",omitempty"`
+
+	// Outbound traffic is queued per connection; a client whose queue overflows or that does not
+	// accept a write within the timeout is disconnected. Applies to new connections.
+	WriteTimeoutSec   int `json:",omitempty"` // default 10
+	OutboundQueueSize int `json:",omitempty"` // frames, default 64
+
+	// When the tenant is removed or the server shuts down, open connections stop reading at the
+	// next frame boundary, get GoodbyeMessage (framed, if set) and are closed once their queued
+	// frames and the tenant's upstream calls are done, at the latest after DrainTimeoutSec
+	// (default 10; on shutdown the server's shutdown timeout applies)
+	GoodbyeMessage  string `json:",omitempty"`
+	DrainTimeoutSec int    `json:",omitempty"`
+
+	//Message format
+	MessageFormat string
+
+	//TargetURL url
+	Endpoint string
+
+	// Outbound rate limit toward Endpoint, shared by all tenants using the same endpoint: the
+	// strictest limit among them applies to all
+	RateLimitPerSec float64 // e.g. 5 -> at most 5 requests per second, 0 sets no limit
+	RateLimitBurst  int     // bucket size, defaults to 1
+}
@@ -0,0 +1,13 @@
+package domain
+
+// TenantPatch is one entry of a /patch request: the config fields to change (zero values are
+// left alone), or Remove to delete the tenant with ID. Older clients identify the tenant by Port
+// instead; Listeners replaces all endpoints of the tenant. Boolean fields are pointers here, so
+// a patch can turn them off.
+type TenantPatch struct {
+	TenantConfig
+	Remove bool `json:"remove,omitempty"`
+
+	AuthRetryOn403     *bool `json:",omitempty"`
+	KeepAliveWriteBack *bool `json:",omitempty"`
+}
@@ -0,0 +1,42 @@
+package domain
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// TenantCounters are a tenant's runtime statistics. They are not part of the config;
+// with a state file configured they are persisted separately and survive restarts.
+type TenantCounters struct {
+	BytesReceived  uint64
+	BytesSent      uint64
+	Errors         uint64
+	Throttled      uint64 // upstream calls answered with 429/503; the message is queued until the pause ends
+	AuthFailures   uint64 // messages given up on token fetch failures or upstream 401 (or 403, see AuthRetryOn403), after the retry
+	UpstreamErrors uint64 // other failed upstream calls (network errors, non-2xx)
+	Messages       uint64 // frames received and forwarded upstream (heartbeats and keep-alive replies excluded)
+	Heartbeats     uint64 // client heartbeats answered locally
+
+	ThrottleDropped uint64 // throttled messages dropped: endpoint queue full, or still queued at the shutdown deadline
+}
+
+// TenantState is the runtime state of a tenant (OAuth tokens live in the token manager).
+type TenantState struct {
+	TenantCounters
+
+	KeepAliveSeq uint64 // sequence number of the last keep-alive sent
+	InFlight     int64  // upstream calls in progress, accessed atomically
+	Queued       int64  // throttled messages waiting for their endpoint, accessed atomically
+
+	// Framing of the current config generation (a TenantFraming value), see Connection.Framing
+	Framing atomic.Value
+
+	Connections     []*Connection
+	ConnectionsLock sync.Mutex
+
+	// Set once the tenant drains (removed or shutting down); connections added after that are
+	// drained right away. Guarded by ConnectionsLock.
+	DrainDeadline time.Time
+	DrainGoodbye  string
+}
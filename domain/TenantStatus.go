@@ -0,0 +1,51 @@
+package domain
+
+import "time"
+
+// TenantStatus is a runtime snapshot of one tenant, as logged and exposed by the admin API.
+type TenantStatus struct {
+	ID          string
+	Name        string
+	Comment     string
+	Connections int
+
+	ConfigGeneration uint64
+	ListenAddresses  []string // configured listeners first, then listeners still accepting after their removal
+
+	ConnectionDetails []ConnectionStatus `json:",omitempty"`
+
+	BytesReceived  uint64
+	BytesSent      uint64
+	Errors         uint64
+	Throttled      uint64
+	AuthFailures   uint64
+	UpstreamErrors uint64
+	Messages       uint64
+	Heartbeats     uint64
+	InFlight       int64 // upstream calls in progress
+
+	Queued          int64 // throttled messages waiting for their endpoint's pause to end
+	ThrottleDropped uint64
+
+	KeepAliveIntervalSec int
+	KeepAliveFile        string
+	KeepAliveNextFire    *time.Time `json:",omitempty"` // nil if no keep-alive is scheduled
+}
+
+// ConnectionStatus is a runtime snapshot of one client connection.
+type ConnectionStatus struct {
+	RemoteAddr       string
+	Endpoint         string // listen address the client connected to
+	ConfigGeneration uint64 // generation whose framing the connection uses
+	ConnectedAt      time.Time
+	LastActivity     time.Time
+	OutboundQueue    int        // frames waiting to be written
+	NextKeepAlive    *time.Time `json:",omitempty"` // only for idle keep-alives (KeepAliveMode "idle")
+
+	// Only tracked if the tenant expects keep-alive replies
+	LastKeepAliveReply    *time.Time `json:",omitempty"`
+	MissedKeepAlives      uint64     // consecutive, reset by a reply
+	MissedKeepAlivesTotal uint64
+
+	LastHeartbeat *time.Time `json:",omitempty"` // last client heartbeat
+}
@@ -0,0 +1,18 @@
+package domain
+
+import "time"
+
+// TokenStatus is the redacted view of one cached OAuth token, as exposed by the admin API.
+type TokenStatus struct {
+	ClientID    string
+	TokenURL    string
+	Scopes      []string
+	Tenants     []string // names of tenants sharing this token
+	Token       string   // redacted, e.g. "eyJh…(812 chars)"
+	TokenType   string
+	TokenExpiry time.Time
+	LastRefresh time.Time
+	LastUsed    time.Time
+	Refreshing  bool
+	LastError   string `json:",omitempty"`
+}
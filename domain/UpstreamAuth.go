@@ -0,0 +1,14 @@
+package domain
+
+// BasicAuthCredentials are sent as HTTP Basic auth (AuthType "basic").
+type BasicAuthCredentials struct {
+	Username string
+	Password string
+}
+
+// APIKeyCredentials are sent as a header or query parameter (AuthType "apikey").
+type APIKeyCredentials struct {
+	Name  string // header or query parameter name, e.g. "X-API-Key" or "api_key"
+	Value string
+	In    string // "header" (default) or "query"
+}
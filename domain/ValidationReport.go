@@ -0,0 +1,17 @@
+package domain
+
+// ValidationIssue is one problem found in a tenants config.
+type ValidationIssue struct {
+	Tenant  string // tenant name, or "tenants[i]" if it has none
+	ID      string `json:",omitempty"`
+	Field   string `json:",omitempty"` // e.g. "StartByte" or "Listeners[0].Address"
+	Message string
+}
+
+// ValidationReport is the result of validating a tenants config. A config with errors is
+// rejected as a whole; warnings are logged but do not prevent loading it.
+type ValidationReport struct {
+	Valid    bool
+	Errors   []ValidationIssue
+	Warnings []ValidationIssue
+}
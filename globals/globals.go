@@ -6,7 +6,7 @@ import (
 	"tcp_sandbox/domain"
 )
 
-// Global maps for tenants and listeners.
+// Global maps for tenants (keyed by ID) and listeners (keyed by listen address).
 var Tenants = make(map[string]*domain.Tenant)
 var Listeners = make(map[string]net.Listener)
 
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// Log levels, most severe first
+const (
+	levelError = iota
+	levelWarn
+	levelInfo
+)
+
+var levelNames = []string{"error", "warn", "info"}
+
+// logWriter filters and formats the output of the log package. The level of a line is taken
+// from its "[ERROR]" or "[WARN]" prefix; all other lines are info.
+type logWriter struct {
+	out      io.Writer
+	minLevel int
+	json     bool
+}
+
+// setupLogging routes the log package through a logWriter.
+func setupLogging(level, format string) error {
+	w := &logWriter{out: os.Stderr, minLevel: -1}
+	for i, name := range levelNames {
+		if strings.EqualFold(level, name) {
+			w.minLevel = i
+		}
+	}
+	if w.minLevel < 0 {
+		return fmt.Errorf("unknown log level %q (info, warn or error)", level)
+	}
+	switch strings.ToLower(format) {
+	case "text":
+	case "json":
+		w.json = true
+	default:
+		return fmt.Errorf("unknown log format %q (text or json)", format)
+	}
+
+	log.SetFlags(0)
+	log.SetOutput(w)
+	return nil
+}
+
+// Write gets one complete log line per call from the log package.
+func (w *logWriter) Write(p []byte) (int, error) {
+	msg := strings.TrimRight(string(p), "\n")
+	level := levelInfo
+	for _, tag := range []struct {
+		prefix string
+		level  int
+	}{{"[ERROR]", levelError}, {"[WARN]", levelWarn}} {
+		if strings.HasPrefix(msg, tag.prefix) {
+			level = tag.level
+			if w.json {
+				msg = strings.TrimLeft(msg[len(tag.prefix):], " ")
+			}
+			break
+		}
+	}
+	if level > w.minLevel {
+		return len(p), nil
+	}
+
+	now := time.Now()
+	var line []byte
+	if w.json {
+		line, _ = json.Marshal(struct {
+			Time  string `json:"time"`
+			Level string `json:"level"`
+			Msg   string `json:"msg"`
+		}{now.Format(time.RFC3339Nano), levelNames[level], msg})
+	} else {
+		line = []byte(now.Format("2006/01/02 15:04:05 ") + msg)
+	}
+	if _, err := w.out.Write(append(line, '\n')); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
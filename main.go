@@ -1,24 +1,52 @@
 package main
 
 import (
-	"log"
-
-	"tcp_sandbox/controller"
-	"tcp_sandbox/service"
+	"fmt"
+	"os"
+	"strings"
 )
 
-func main() {
-	const tenantFile = "tenants.json"
-
-	if err := service.LoadTenantsFromFile(tenantFile); err != nil {
-		log.Fatalf("Failed to load tenants from file: %v", err)
-	}
+const usage = `Usage: tcp_sandbox [command] [flags]
 
-	service.StartAllTenants()
+Commands:
+  serve       run the server (default)
+  validate    check a tenants file
+  tenants     list, add, remove or patch tenants of a running server
+  send        send framed test messages to a tenant listener
+  encrypt     encrypt a secret for tenants.json
+  rotate-key  re-encrypt the tenants file with a new master key
 
-	go controller.StartRESTServer()
+Run "tcp_sandbox <command> -h" for the flags of a command.
+`
 
-	go service.StartTenantFileManager(tenantFile)
+func main() {
+	cmd, args := "serve", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		cmd, args = args[0], args[1:]
+	}
 
-	select {} // block forever
+	var err error
+	switch cmd {
+	case "serve":
+		err = runServe(args)
+	case "validate":
+		err = runValidate(args)
+	case "tenants":
+		err = runTenants(args)
+	case "send":
+		err = runSend(args)
+	case "encrypt":
+		err = runEncrypt(args)
+	case "rotate-key":
+		err = runRotateKey(args)
+	case "help":
+		fmt.Print(usage)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", cmd, usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
 }
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"tcp_sandbox/controller"
+	"tcp_sandbox/service"
+)
+
+// -----------------------------------------------------------
+// Zero-Downtime Restart (listener handoff to a new process)
+// -----------------------------------------------------------
+
+// readyFDEnv tells a restarted process which fd to report readiness on.
+const readyFDEnv = "TCP_SANDBOX_READY_FD"
+
+// restartTimeout is how long the new process may take to start serving.
+const restartTimeout = 30 * time.Second
+
+// restartFiles collects the listening sockets of all tenant listeners and the admin API, and
+// the ListenerFDsEnv value describing them once passed as extra files after the ready pipe.
+func restartFiles() ([]*os.File, string, error) {
+	addrs, files, err := service.ListenerFiles()
+	if err != nil {
+		return nil, "", err
+	}
+	adminAddr, adminFile, err := controller.AdminListenerFile()
+	if err != nil {
+		closeFiles(files)
+		return nil, "", fmt.Errorf("admin API: %w", err)
+	}
+	addrs, files = append(addrs, adminAddr), append(files, adminFile)
+	return files, service.EncodeListenerFDs(addrs, 4), nil // fd 3 is the ready pipe
+}
+
+// restartEnv is the environment of the new process: ours without earlier handoff variables.
+func restartEnv(listenerFDs string) []string {
+	var env []string
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, service.ListenerFDsEnv+"=") && !strings.HasPrefix(kv, readyFDEnv+"=") {
+			env = append(env, kv)
+		}
+	}
+	return append(env, service.ListenerFDsEnv+"="+listenerFDs, readyFDEnv+"=3")
+}
+
+// notifyReady tells the previous process that we are ready to serve, if we were started by a
+// restart. Listeners accept only afterwards: an error means the previous process gave up on us
+// and keeps serving, so we must exit without having accepted anything.
+func notifyReady() error {
+	value := os.Getenv(readyFDEnv)
+	if value == "" {
+		return nil
+	}
+	os.Unsetenv(readyFDEnv)
+	fd, err := strconv.Atoi(value)
+	if err != nil {
+		return fmt.Errorf("invalid %s %q", readyFDEnv, value)
+	}
+	f := os.NewFile(uintptr(fd), "ready")
+	defer f.Close()
+	_, err = f.Write([]byte("ready\n"))
+	return err
+}
+
+func closeFiles(files []*os.File) {
+	for _, f := range files {
+		f.Close()
+	}
+}
@@ -0,0 +1,107 @@
+//go:build !windows
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+
+	"tcp_sandbox/service"
+)
+
+// restartSignal makes the server restart (see restart).
+var restartSignal os.Signal = syscall.SIGUSR2
+
+// restart starts the current executable again with the same arguments, handing over the
+// listening sockets, and returns once the new process is ready to serve. Until then we keep
+// serving and the new process does not accept, so clients never find a port closed and stopping
+// it loses none. On success we are marked as shutting down, so that no reload starts listeners
+// the new process should own; the caller then drains and exits. On error the new process has
+// been stopped and we simply carry on.
+func restart(stateFile string) (int, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return 0, err
+	}
+	files, listenerFDs, err := restartFiles()
+	if err != nil {
+		return 0, err
+	}
+	defer closeFiles(files)
+
+	ready, readyW, err := os.Pipe()
+	if err != nil {
+		return 0, err
+	}
+	defer ready.Close()
+
+	// the new process starts from our counters
+	if stateFile != "" {
+		if err := service.SaveTenantState(stateFile); err != nil {
+			readyW.Close()
+			return 0, fmt.Errorf("saving state file: %w", err)
+		}
+	}
+
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.Env = restartEnv(listenerFDs)
+	cmd.ExtraFiles = append([]*os.File{readyW}, files...)
+	err = cmd.Start()
+	readyW.Close() // only the new process holds the write end now
+	if err != nil {
+		return 0, err
+	}
+
+	result := make(chan error, 1)
+	go func() {
+		line, err := bufio.NewReader(ready).ReadString('\n')
+		if err == nil && line != "ready\n" {
+			err = fmt.Errorf("unexpected %q", line)
+		} else if err != nil {
+			err = errors.New("new process exited before it was ready")
+		}
+		result <- err
+	}()
+
+	timer := time.NewTimer(restartTimeout)
+	defer timer.Stop()
+	select {
+	case err = <-result:
+	case <-timer.C:
+		// closing the pipe fails a later notification, unless it just made it
+		ready.Close()
+		if <-result != nil {
+			err = fmt.Errorf("new process not ready after %s", restartTimeout)
+		}
+	}
+	if err != nil {
+		stopChild(cmd)
+		return 0, err
+	}
+	service.BeginShutdown()
+	go cmd.Wait() // reap it should it exit before we do
+	return cmd.Process.Pid, nil
+}
+
+// stopChild stops a new process that failed to get ready. It is asked to shut down first, in
+// case it got ready after all and has clients to drain, and killed if it does not exit in time.
+func stopChild(cmd *exec.Cmd) {
+	exited := make(chan struct{})
+	go func() {
+		_ = cmd.Wait()
+		close(exited)
+	}()
+	_ = cmd.Process.Signal(syscall.SIGTERM)
+	select {
+	case <-exited:
+	case <-time.After(restartTimeout):
+		_ = cmd.Process.Kill()
+		<-exited
+	}
+}
@@ -0,0 +1,15 @@
+//go:build windows
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// restartSignal is nil: listener handoff needs inheritable sockets and SIGUSR2.
+var restartSignal os.Signal
+
+func restart(stateFile string) (int, error) {
+	return 0, errors.New("restart with listener handoff is not supported on this platform")
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"tcp_sandbox/controller"
+	"tcp_sandbox/service"
+)
+
+// Environment variables for the flags of serve (flags take precedence)
+const (
+	configEnv    = "TCP_SANDBOX_CONFIG"
+	adminAddrEnv = "TCP_SANDBOX_ADMIN_ADDR"
+	reloadEnv    = "TCP_SANDBOX_RELOAD"
+	logLevelEnv  = "TCP_SANDBOX_LOG_LEVEL"
+	logFormatEnv = "TCP_SANDBOX_LOG_FORMAT"
+	shutdownEnv  = "TCP_SANDBOX_SHUTDOWN_TIMEOUT"
+)
+
+const (
+	defaultConfigFile      = "tenants.json"
+	defaultAdminAddr       = ":8080"
+	defaultShutdownTimeout = "30s"
+)
+
+// runServe runs the server until it gets SIGINT or SIGTERM, then shuts down gracefully. On
+// SIGUSR2 it restarts: a new process takes over the listeners and this one drains and exits.
+//
+//	tcp_sandbox serve [-config tenants.json] [-admin-addr :8080] [-reload watch|poll|off]
+//	                  [-log-level info|warn|error] [-log-format text|json] [-state-file path]
+//	                  [-shutdown-timeout 30s]
+func runServe(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	configFile := fs.String("config", envOr(configEnv, defaultConfigFile), "tenants file (env "+configEnv+")")
+	adminAddr := fs.String("admin-addr", envOr(adminAddrEnv, defaultAdminAddr), "listen address of the admin API (env "+adminAddrEnv+")")
+	reload := fs.String("reload", envOr(reloadEnv, service.ReloadWatch), "tenants file reload: watch, poll or off (env "+reloadEnv+")")
+	logLevel := fs.String("log-level", envOr(logLevelEnv, "info"), "minimum log level: info, warn or error (env "+logLevelEnv+")")
+	logFormat := fs.String("log-format", envOr(logFormatEnv, "text"), "log format: text or json (env "+logFormatEnv+")")
+	stateFile := fs.String("state-file", os.Getenv(service.StateFileEnv), "file to persist counters to, empty to disable (env "+service.StateFileEnv+")")
+	shutdownTimeout := fs.String("shutdown-timeout", envOr(shutdownEnv, defaultShutdownTimeout), "how long connections may drain on SIGINT/SIGTERM (env "+shutdownEnv+")")
+	fs.Parse(args)
+
+	if fs.NArg() > 0 {
+		return fmt.Errorf("unexpected arguments: %v", fs.Args())
+	}
+	drainTimeout, err := time.ParseDuration(*shutdownTimeout)
+	if err != nil {
+		return fmt.Errorf("invalid shutdown timeout: %w", err)
+	}
+	switch *reload {
+	case service.ReloadWatch, service.ReloadPoll, service.ReloadOff:
+	default:
+		return fmt.Errorf("unknown reload mode %q (watch, poll or off)", *reload)
+	}
+	if err := setupLogging(*logLevel, *logFormat); err != nil {
+		return err
+	}
+
+	if v := os.Getenv(service.ConfigVersionsEnv); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", service.ConfigVersionsEnv, err)
+		}
+		service.ConfigVersionsToKeep = n
+	}
+
+	// a restart may stop us while we start up (see restart); handle that as a shutdown
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	restarts := make(chan os.Signal, 1)
+	if restartSignal != nil {
+		signal.Notify(restarts, restartSignal)
+	}
+
+	if err := service.InheritListeners(); err != nil {
+		return fmt.Errorf("taking over listeners: %w", err)
+	}
+
+	if err := service.LoadTenantsFromFile(*configFile); err != nil {
+		return fmt.Errorf("loading tenants from file: %w", err)
+	}
+
+	if *stateFile != "" {
+		if err := service.LoadTenantState(*stateFile); err != nil {
+			log.Printf("[WARN] Could not restore counters from %s: %v", *stateFile, err)
+		}
+	}
+
+	service.StartAllTenants()
+
+	go service.StartTokenManager()
+
+	if err := controller.StartRESTServer(*adminAddr, *configFile); err != nil {
+		return fmt.Errorf("starting admin API: %w", err)
+	}
+	service.CloseInheritedListeners()
+	if err := notifyReady(); err != nil {
+		return fmt.Errorf("previous process did not take the handoff, exiting without accepting: %w", err)
+	}
+	service.ReleaseAccepting()
+
+	go service.StartTenantFileManager(*configFile, *reload)
+
+	if *stateFile != "" {
+		go service.StartStateSaver(*stateFile)
+	}
+
+	for {
+		select {
+		case sig := <-signals:
+			log.Printf("Received %s, shutting down (draining for up to %s, send again to exit now)", sig, drainTimeout)
+			go exitOnSignal(signals)
+			shutdown(drainTimeout, *stateFile)
+			return nil
+		case sig := <-restarts:
+			log.Printf("Received %s, restarting", sig)
+			pid, err := restart(*stateFile)
+			if err != nil {
+				log.Printf("[ERROR] Restart failed, this process keeps serving: %v", err)
+				continue
+			}
+			log.Printf("Process %d took over the listeners, draining for up to %s", pid, drainTimeout)
+			go exitOnSignal(signals)
+			handOver(drainTimeout)
+			return nil
+		}
+	}
+}
+
+func exitOnSignal(signals <-chan os.Signal) {
+	<-signals
+	log.Printf("[WARN] Second signal, exiting without draining")
+	os.Exit(1)
+}
+
+// shutdown stops accepting clients, drains the tenants' connections and upstream calls, stops the
+// admin API and persists the state.
+func shutdown(timeout time.Duration, stateFile string) {
+	service.Shutdown(timeout)
+	stopAdmin()
+
+	if stateFile != "" {
+		if err := service.SaveTenantState(stateFile); err != nil {
+			log.Printf("[ERROR] Could not save state file: %v", err)
+		}
+	}
+	log.Printf("Shutdown complete")
+}
+
+// handOver drains this process after a restart. The admin API goes first, so that changes
+// reach the new process; the state file is left to the new process.
+func handOver(timeout time.Duration) {
+	stopAdmin()
+	service.Shutdown(timeout)
+	log.Printf("Drained, exiting")
+}
+
+func stopAdmin() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := controller.StopRESTServer(ctx); err != nil {
+		log.Printf("[WARN] Admin API did not stop cleanly: %v", err)
+	}
+}
+
+// envOr returns the environment variable, or def if it is not set.
+func envOr(name, def string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return def
+}
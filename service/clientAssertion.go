@@ -0,0 +1,162 @@
+package service
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"tcp_sandbox/domain"
+)
+
+// -----------------------------------------------------------
+// private_key_jwt Client Assertions (RFC 7523)
+// -----------------------------------------------------------
+
+const (
+	clientAssertionType     = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+	clientAssertionLifetime = 5 * time.Minute
+)
+
+// buildClientAssertion returns a signed JWT identifying the client at the token endpoint.
+func buildClientAssertion(c domain.OAuthCredentials) (string, error) {
+	if c.PrivateKeyFile == "" {
+		return "", errors.New("private_key_jwt requires PrivateKeyFile")
+	}
+	key, err := loadPrivateKey(c.PrivateKeyFile)
+	if err != nil {
+		return "", err
+	}
+
+	alg := strings.ToUpper(c.SigningAlgorithm)
+	if alg == "" {
+		if _, ok := key.(*ecdsa.PrivateKey); ok {
+			alg = "ES256"
+		} else {
+			alg = "RS256"
+		}
+	}
+
+	jti := make([]byte, 16)
+	if _, err := rand.Read(jti); err != nil {
+		return "", err
+	}
+	now := time.Now()
+
+	header := map[string]string{"alg": alg, "typ": "JWT"}
+	if c.KeyID != "" {
+		header["kid"] = c.KeyID
+	}
+	claims := map[string]interface{}{
+		"iss": c.ClientID,
+		"sub": c.ClientID,
+		"aud": c.TokenURL,
+		"jti": hex.EncodeToString(jti),
+		"iat": now.Unix(),
+		"exp": now.Add(clientAssertionLifetime).Unix(),
+	}
+
+	headerJSON, err := json.Marshal(header)
+	if err != nil {
+		return "", err
+	}
+	claimsJSON, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signingInput := base64.RawURLEncoding.EncodeToString(headerJSON) + "." + base64.RawURLEncoding.EncodeToString(claimsJSON)
+
+	sig, err := signJWT(alg, key, []byte(signingInput))
+	if err != nil {
+		return "", err
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// loadPrivateKey reads a PEM encoded PKCS#1, PKCS#8 or SEC 1 private key.
+func loadPrivateKey(path string) (crypto.Signer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading private key: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data in %s", path)
+	}
+
+	if k, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return k, nil
+	}
+	if k, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return k, nil
+	}
+	k, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("unsupported private key in %s: %w", path, err)
+	}
+	switch k := k.(type) {
+	case *rsa.PrivateKey:
+		return k, nil
+	case *ecdsa.PrivateKey:
+		return k, nil
+	default:
+		return nil, fmt.Errorf("unsupported private key type %T in %s", k, path)
+	}
+}
+
+// signJWT signs the JWS signing input with the given algorithm.
+func signJWT(alg string, key crypto.Signer, input []byte) ([]byte, error) {
+	var hash crypto.Hash
+	switch alg {
+	case "RS256", "PS256", "ES256":
+		hash = crypto.SHA256
+	case "RS384", "ES384":
+		hash = crypto.SHA384
+	case "RS512":
+		hash = crypto.SHA512
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm %q", alg)
+	}
+	h := hash.New()
+	h.Write(input)
+	digest := h.Sum(nil)
+
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		switch alg {
+		case "RS256", "RS384", "RS512":
+			return rsa.SignPKCS1v15(rand.Reader, k, hash, digest)
+		case "PS256":
+			return rsa.SignPSS(rand.Reader, k, hash, digest, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+		}
+	case *ecdsa.PrivateKey:
+		if (alg == "ES256" && k.Curve != elliptic.P256()) || (alg == "ES384" && k.Curve != elliptic.P384()) {
+			return nil, fmt.Errorf("%s does not match the key's curve %s", alg, k.Curve.Params().Name)
+		}
+		if alg != "ES256" && alg != "ES384" {
+			break
+		}
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest)
+		if err != nil {
+			return nil, err
+		}
+		// JWS wants the fixed-size r || s encoding, not ASN.1
+		size := (k.Curve.Params().BitSize + 7) / 8
+		sig := make([]byte, 2*size)
+		r.FillBytes(sig[:size])
+		s.FillBytes(sig[size:])
+		return sig, nil
+	}
+	return nil, fmt.Errorf("signing algorithm %q does not match key type %T", alg, key)
+}
@@ -0,0 +1,113 @@
+package service
+
+import (
+	"log"
+	"reflect"
+	"strings"
+
+	"tcp_sandbox/domain"
+)
+
+// -----------------------------------------------------------
+// Config Generations & Per-Connection Framing
+// -----------------------------------------------------------
+
+// appliedConfigs is the config each tenant's current generation was made from.
+// Guarded by globals.TenantsLock.
+var appliedConfigs = make(map[*domain.Tenant]domain.TenantConfig)
+
+// updateConfigGeneration starts a new generation if the tenant's config changed since the last
+// one. Caller holds globals.TenantsLock.
+func updateConfigGeneration(t *domain.Tenant) {
+	prev, ok := appliedConfigs[t]
+	if ok && reflect.DeepEqual(prev, t.TenantConfig) {
+		return
+	}
+	appliedConfigs[t] = t.TenantConfig
+
+	cur := loadTenantFraming(t)
+	next := domain.TenantFraming{
+		Framing: domain.Framing{
+			StartByte:        t.StartByte,
+			EndByte:          t.EndByte,
+			Generation:       cur.Generation + 1,
+			SwitchAtBoundary: strings.EqualFold(t.FramingChange, "next-frame"),
+		},
+		Endpoints: make(map[string]domain.Framing),
+	}
+	// Listeners still accepting after their removal keep the framing clients expect there
+	for addr, f := range cur.Endpoints {
+		if hasListener(t, addr) {
+			next.Endpoints[addr] = f
+		}
+	}
+	for _, l := range t.Listeners {
+		f := next.Framing
+		if l.StartByte != 0 {
+			f.StartByte = l.StartByte
+		}
+		if l.EndByte != 0 {
+			f.EndByte = l.EndByte
+		}
+		next.Endpoints[l.Address] = f
+	}
+	t.Framing.Store(next)
+}
+
+// forgetRemovedGenerations drops tenants no longer in globals.Tenants.
+// Caller holds globals.TenantsLock.
+func forgetRemovedGenerations() {
+	for t := range appliedConfigs {
+		if !isActiveTenant(t) {
+			delete(appliedConfigs, t)
+		}
+	}
+}
+
+// loadTenantFraming returns the framing of the tenant's current generation.
+func loadTenantFraming(t *domain.Tenant) domain.TenantFraming {
+	if f, ok := t.Framing.Load().(domain.TenantFraming); ok {
+		return f
+	}
+	return domain.TenantFraming{Framing: domain.Framing{StartByte: t.StartByte, EndByte: t.EndByte}}
+}
+
+// tenantFraming returns the framing of the tenant's current generation on a listen address.
+func tenantFraming(t *domain.Tenant, addr string) domain.Framing {
+	tf := loadTenantFraming(t)
+	if f, ok := tf.Endpoints[addr]; ok {
+		return f
+	}
+	return tf.Framing
+}
+
+// connFraming returns the framing a connection currently uses.
+func connFraming(c *domain.Connection) domain.Framing {
+	f, _ := c.Framing.Load().(domain.Framing)
+	return f
+}
+
+// nextFraming is called at frame boundaries: it switches the connection to the tenant's current
+// generation if the tenant asks for that, and returns the framing to use.
+func nextFraming(t *domain.Tenant, c *domain.Connection) domain.Framing {
+	cur := connFraming(c)
+	latest := tenantFraming(t, c.Endpoint)
+	if !latest.SwitchAtBoundary || latest.Generation == cur.Generation {
+		return cur
+	}
+	c.Framing.Store(latest)
+	if latest.StartByte != cur.StartByte || latest.EndByte != cur.EndByte {
+		log.Printf("[Tenant %q] Connection %s switched to framing %q/%q (generation %d)",
+			t.Name, c.Conn.RemoteAddr(), latest.StartByte, latest.EndByte, latest.Generation)
+	}
+	return latest
+}
+
+// frame wraps a payload in the connection's StartByte/EndByte.
+func frame(c *domain.Connection, payload []byte) []byte {
+	f := connFraming(c)
+	out := make([]byte, 0, len(payload)+2)
+	out = append(out, f.StartByte)
+	out = append(out, payload...)
+	return append(out, f.EndByte)
+}
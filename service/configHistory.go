@@ -0,0 +1,193 @@
+package service
+
+import (
+	"encoding/json"
+	"log"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"tcp_sandbox/domain"
+	"tcp_sandbox/globals"
+)
+
+// -----------------------------------------------------------
+// Config Diffs & Reload History
+// -----------------------------------------------------------
+
+// configHistorySize is the number of diffs kept in memory.
+const configHistorySize = 50
+
+// Config change sources
+const (
+	SourceFile     = "file"
+	SourcePatch    = "patch"
+	SourceRollback = "rollback"
+)
+
+var (
+	configHistory     []domain.ConfigDiff
+	configHistoryLock sync.Mutex
+)
+
+// TenantsSnapshot is the config of all tenants at one point in time, keyed by ID.
+type TenantsSnapshot map[string]tenantConfig
+
+type tenantConfig struct {
+	name    string
+	fields  map[string]interface{} // flattened, e.g. "OAuthCredentials.ClientID"
+	secrets map[string]bool        // field paths holding secrets
+}
+
+// SnapshotTenants captures the current config for a later RecordConfigChange.
+// Caller holds globals.TenantsLock.
+func SnapshotTenants() TenantsSnapshot {
+	snap := make(TenantsSnapshot, len(globals.Tenants))
+	for id, t := range globals.Tenants {
+		if t.Name == "" {
+			continue // marked for removal
+		}
+		c := cloneTenant(t)
+		cfg := tenantConfig{name: t.Name, fields: make(map[string]interface{}), secrets: make(map[string]bool)}
+
+		var raw map[string]interface{}
+		data, _ := json.Marshal(c)
+		json.Unmarshal(data, &raw)
+		flattenConfig("", raw, cfg.fields)
+		for _, f := range secretFields(c) {
+			cfg.secrets[f.Path] = true
+		}
+		snap[id] = cfg
+	}
+	return snap
+}
+
+// flattenConfig turns nested JSON objects into dotted paths; arrays are kept as values.
+func flattenConfig(prefix string, v map[string]interface{}, out map[string]interface{}) {
+	for k, val := range v {
+		path := k
+		if prefix != "" {
+			path = prefix + "." + k
+		}
+		if m, ok := val.(map[string]interface{}); ok {
+			flattenConfig(path, m, out)
+			continue
+		}
+		out[path] = val
+	}
+}
+
+// RecordConfigChange computes the diff between two snapshots, logs it and adds it to the history.
+// Changes without effect are ignored.
+func RecordConfigChange(source string, before, after TenantsSnapshot) {
+	diff := diffTenants(before, after)
+	if len(diff.Added) == 0 && len(diff.Removed) == 0 && len(diff.Modified) == 0 {
+		log.Printf("[Config] %s change without effect on the tenant config", source)
+		return
+	}
+	diff.Time = time.Now()
+	diff.Source = source
+	logConfigDiff(diff)
+
+	configHistoryLock.Lock()
+	defer configHistoryLock.Unlock()
+	configHistory = append(configHistory, diff)
+	if len(configHistory) > configHistorySize {
+		configHistory = configHistory[len(configHistory)-configHistorySize:]
+	}
+}
+
+// ConfigHistory returns the recorded diffs, newest first.
+func ConfigHistory() []domain.ConfigDiff {
+	configHistoryLock.Lock()
+	defer configHistoryLock.Unlock()
+
+	out := make([]domain.ConfigDiff, 0, len(configHistory))
+	for i := len(configHistory) - 1; i >= 0; i-- {
+		out = append(out, configHistory[i])
+	}
+	return out
+}
+
+func diffTenants(before, after TenantsSnapshot) domain.ConfigDiff {
+	var diff domain.ConfigDiff
+	for _, id := range sortedIDs(after) {
+		a := after[id]
+		b, existed := before[id]
+		if !existed {
+			diff.Added = append(diff.Added, domain.TenantDiff{ID: id, Name: a.name})
+			continue
+		}
+		if fields := diffFields(b, a); len(fields) > 0 {
+			diff.Modified = append(diff.Modified, domain.TenantDiff{ID: id, Name: a.name, Fields: fields})
+		}
+	}
+	for _, id := range sortedIDs(before) {
+		if _, ok := after[id]; !ok {
+			diff.Removed = append(diff.Removed, domain.TenantDiff{ID: id, Name: before[id].name})
+		}
+	}
+	return diff
+}
+
+func diffFields(before, after tenantConfig) []domain.FieldChange {
+	paths := make(map[string]bool)
+	for p := range before.fields {
+		paths[p] = true
+	}
+	for p := range after.fields {
+		paths[p] = true
+	}
+	sorted := make([]string, 0, len(paths))
+	for p := range paths {
+		sorted = append(sorted, p)
+	}
+	sort.Strings(sorted)
+
+	var changes []domain.FieldChange
+	for _, p := range sorted {
+		old, cur := before.fields[p], after.fields[p]
+		if reflect.DeepEqual(old, cur) {
+			continue
+		}
+		if before.secrets[p] || after.secrets[p] {
+			old, cur = redactIfSet(old), redactIfSet(cur)
+		}
+		changes = append(changes, domain.FieldChange{Field: p, Old: old, New: cur})
+	}
+	return changes
+}
+
+func redactIfSet(v interface{}) interface{} {
+	if v == nil || v == "" {
+		return v
+	}
+	return redactedValue
+}
+
+func sortedIDs(s TenantsSnapshot) []string {
+	ids := make([]string, 0, len(s))
+	for id := range s {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func logConfigDiff(d domain.ConfigDiff) {
+	for _, t := range d.Added {
+		log.Printf("[Config][%s] Added tenant %q (id %s)", d.Source, t.Name, t.ID)
+	}
+	for _, t := range d.Removed {
+		log.Printf("[Config][%s] Removed tenant %q (id %s)", d.Source, t.Name, t.ID)
+	}
+	for _, t := range d.Modified {
+		fields := make([]string, 0, len(t.Fields))
+		for _, f := range t.Fields {
+			fields = append(fields, f.Field)
+		}
+		log.Printf("[Config][%s] Modified tenant %q (id %s): %s", d.Source, t.Name, t.ID, strings.Join(fields, ", "))
+	}
+}
@@ -0,0 +1,55 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+
+	"tcp_sandbox/domain"
+	"tcp_sandbox/globals"
+)
+
+func snapshotOf(t *testing.T, tenants ...*domain.Tenant) TenantsSnapshot {
+	withTenants(t, tenants...)
+	globals.TenantsLock.Lock()
+	defer globals.TenantsLock.Unlock()
+	return SnapshotTenants()
+}
+
+func TestDiffTenants(t *testing.T) {
+	tenant := func(id, comment, token string) *domain.Tenant {
+		return &domain.Tenant{TenantConfig: domain.TenantConfig{
+			ID: id, Name: "Tenant " + id, Comment: comment, BearerToken: token,
+			Listeners: []domain.ListenerConfig{{Address: ":3000"}},
+		}}
+	}
+	before := snapshotOf(t, tenant("a", "old", "token-1"), tenant("b", "", ""))
+	changed := tenant("a", "new", "token-2")
+	changed.OAuthCredentials.TokenURL = "https://auth.example.com/token"
+	after := snapshotOf(t, changed, tenant("c", "", ""))
+
+	got := diffTenants(before, after)
+	want := domain.ConfigDiff{
+		Added:   []domain.TenantDiff{{ID: "c", Name: "Tenant c"}},
+		Removed: []domain.TenantDiff{{ID: "b", Name: "Tenant b"}},
+		Modified: []domain.TenantDiff{{ID: "a", Name: "Tenant a", Fields: []domain.FieldChange{
+			{Field: "BearerToken", Old: redactedValue, New: redactedValue},
+			{Field: "Comment", Old: "old", New: "new"},
+			{Field: "OAuthCredentials.TokenURL", Old: "", New: "https://auth.example.com/token"},
+		}}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("diff:\n%+v\nwant:\n%+v", got, want)
+	}
+
+	if d := diffTenants(after, after); len(d.Added)+len(d.Removed)+len(d.Modified) != 0 {
+		t.Errorf("diff of a snapshot with itself: %+v", d)
+	}
+}
+
+func TestSnapshotSkipsTenantsMarkedForRemoval(t *testing.T) {
+	removed := &domain.Tenant{TenantConfig: domain.TenantConfig{ID: "gone"}}
+	snap := snapshotOf(t, removed, &domain.Tenant{TenantConfig: domain.TenantConfig{ID: "kept", Name: "Kept"}})
+	if _, ok := snap["gone"]; ok || len(snap) != 1 {
+		t.Errorf("snapshot has %d tenant(s), want only the kept one", len(snap))
+	}
+}
@@ -0,0 +1,227 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"tcp_sandbox/domain"
+)
+
+// -----------------------------------------------------------
+// Config Versions (atomic writes, previous versions, rollback)
+// -----------------------------------------------------------
+
+// ConfigVersionsToKeep is the number of previous tenants file versions kept next to the file
+// (0 disables versions). It can be set with the ConfigVersionsEnv environment variable.
+var ConfigVersionsToKeep = 10
+
+// ConfigVersionsEnv overrides ConfigVersionsToKeep.
+const ConfigVersionsEnv = "TCP_SANDBOX_CONFIG_VERSIONS"
+
+// versionIDLayout names version files; it sorts chronologically.
+const versionIDLayout = "20060102T150405.000000000Z"
+
+// ErrVersionNotFound is returned for an unknown version ID.
+var ErrVersionNotFound = errors.New("config version not found")
+
+var tenantsFileWriteLock sync.Mutex
+
+// versionsDir is where previous versions of filename are kept, e.g. "tenants.json.versions".
+func versionsDir(filename string) string {
+	return filename + ".versions"
+}
+
+// writeTenantsFile replaces the tenants file atomically, keeping its current content as a version.
+func writeTenantsFile(filename string, data []byte) error {
+	tenantsFileWriteLock.Lock()
+	defer tenantsFileWriteLock.Unlock()
+
+	if err := archiveTenantsFile(filename); err != nil {
+		log.Printf("[WARN] Could not keep previous version of %s: %v", filename, err)
+	}
+	if err := writeFileAtomic(filename, data, 0644); err != nil {
+		return err
+	}
+	// Our own write must not trigger a reload
+	rememberTenantsFile(data)
+	return nil
+}
+
+// writeFileAtomic writes data to a temporary file in the same directory, syncs it and renames it
+// over filename, so readers (and a crash) see either the old or the new content.
+func writeFileAtomic(filename string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(filename)
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(filename)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // no-op after the rename
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), filename); err != nil {
+		return err
+	}
+
+	// Persist the rename itself
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
+
+// archiveTenantsFile copies the current tenants file into the versions directory, unless it is
+// identical to the newest version, and prunes versions beyond ConfigVersionsToKeep.
+func archiveTenantsFile(filename string) error {
+	if ConfigVersionsToKeep <= 0 {
+		return nil
+	}
+	data, err := os.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	dir := versionsDir(filename)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	versions, err := ListConfigVersions(filename)
+	if err != nil {
+		return err
+	}
+	if len(versions) > 0 {
+		if latest, err := os.ReadFile(versionPath(filename, versions[0].ID)); err == nil && bytes.Equal(latest, data) {
+			return nil
+		}
+	}
+
+	id := time.Now().UTC().Format(versionIDLayout)
+	if err := writeFileAtomic(versionPath(filename, id), data, 0600); err != nil {
+		return err
+	}
+
+	versions, err = ListConfigVersions(filename)
+	if err != nil {
+		return err
+	}
+	for _, v := range versions[minInt(len(versions), ConfigVersionsToKeep):] {
+		os.Remove(versionPath(filename, v.ID))
+	}
+	return nil
+}
+
+func versionPath(filename, id string) string {
+	return filepath.Join(versionsDir(filename), id+".json")
+}
+
+// ListConfigVersions returns the kept versions of the tenants file, newest first.
+func ListConfigVersions(filename string) ([]domain.ConfigVersion, error) {
+	entries, err := os.ReadDir(versionsDir(filename))
+	if os.IsNotExist(err) {
+		return []domain.ConfigVersion{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	versions := []domain.ConfigVersion{}
+	for _, e := range entries {
+		id := strings.TrimSuffix(e.Name(), ".json")
+		at, err := time.Parse(versionIDLayout, id)
+		if err != nil || e.IsDir() {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		versions = append(versions, domain.ConfigVersion{ID: id, Time: at, Size: info.Size()})
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i].ID > versions[j].ID })
+	return versions, nil
+}
+
+// readConfigVersion returns the content of one version.
+func readConfigVersion(filename, id string) ([]byte, error) {
+	if _, err := time.Parse(versionIDLayout, id); err != nil {
+		return nil, ErrVersionNotFound
+	}
+	data, err := os.ReadFile(versionPath(filename, id))
+	if os.IsNotExist(err) {
+		return nil, ErrVersionNotFound
+	}
+	return data, err
+}
+
+// ConfigVersionTenants returns the tenants of one version with plain secrets redacted;
+// references and encrypted values are shown as stored.
+func ConfigVersionTenants(filename, id string) ([]*domain.Tenant, error) {
+	data, err := readConfigVersion(filename, id)
+	if err != nil {
+		return nil, err
+	}
+	var tenants []*domain.Tenant
+	if err := json.Unmarshal(data, &tenants); err != nil {
+		return nil, fmt.Errorf("json unmarshal error: %w", err)
+	}
+	for _, t := range tenants {
+		for _, f := range secretFields(t) {
+			if v := f.Get(); v != "" && !isEncryptedValue(v) && !secretRefPattern.MatchString(v) {
+				f.Set(redactedValue)
+			}
+		}
+	}
+	return tenants, nil
+}
+
+// RollbackConfig applies a previous version live, like a reload of the tenants file, and then
+// makes it the current tenants file. A version that no longer validates is rejected.
+func RollbackConfig(filename, id string) error {
+	data, err := readConfigVersion(filename, id)
+	if err != nil {
+		return err
+	}
+	migrated, err := applyTenantsConfig(data, SourceRollback)
+	if err != nil {
+		return err
+	}
+	log.Printf("[Config] Rolled back %s to version %s", filename, id)
+	if migrated {
+		// A version in an older format is written back migrated, like a reload does
+		return SaveTenantsToFile(filename)
+	}
+	return writeTenantsFile(filename, data)
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
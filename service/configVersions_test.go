@@ -0,0 +1,121 @@
+package service
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// tempFiles lists leftover temporary files of writeFileAtomic in dir.
+func tempFiles(t *testing.T, dir string) []string {
+	matches, err := filepath.Glob(filepath.Join(dir, ".*.tmp-*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return matches
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "tenants.json")
+
+	if err := writeFileAtomic(path, []byte("one"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeFileAtomic(path, []byte("two"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil || string(data) != "two" {
+		t.Errorf("content %q, %v; want %q", data, err, "two")
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0640 {
+		t.Errorf("mode %v, %v; want 0640", info.Mode().Perm(), err)
+	}
+	if left := tempFiles(t, dir); len(left) > 0 {
+		t.Errorf("temporary files left: %v", left)
+	}
+
+	// the rename fails onto a non-empty directory: no temporary file is left behind
+	target := filepath.Join(dir, "sub")
+	if err := os.MkdirAll(filepath.Join(target, "x"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeFileAtomic(target, []byte("three"), 0644); err == nil {
+		t.Errorf("writing over a directory succeeded")
+	}
+	if left := tempFiles(t, dir); len(left) > 0 {
+		t.Errorf("temporary files left after a failed write: %v", left)
+	}
+
+	if err := writeFileAtomic(filepath.Join(dir, "missing", "f.json"), []byte("x"), 0644); err == nil {
+		t.Errorf("writing into a missing directory succeeded")
+	}
+}
+
+func TestTenantsFileVersions(t *testing.T) {
+	saved := ConfigVersionsToKeep
+	ConfigVersionsToKeep = 2
+	t.Cleanup(func() { ConfigVersionsToKeep = saved })
+
+	path := filepath.Join(t.TempDir(), "tenants.json")
+	for _, content := range []string{"v1", "v2", "v2", "v3", "v4"} {
+		if err := writeTenantsFile(path, []byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	versions, err := ListConfigVersions(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, v := range versions {
+		data, err := readConfigVersion(path, v.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, string(data))
+	}
+	// newest first, the unchanged save of v2 not kept twice, pruned to 2
+	if len(got) != 2 || got[0] != "v3" || got[1] != "v2" {
+		t.Errorf("versions %v, want [v3 v2]", got)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "v4" {
+		t.Errorf("current file %q, want v4", data)
+	}
+
+	for _, id := range []string{"nope", "../tenants", "20000101T000000.000000000Z"} {
+		if _, err := readConfigVersion(path, id); !errors.Is(err, ErrVersionNotFound) {
+			t.Errorf("readConfigVersion(%q): %v, want ErrVersionNotFound", id, err)
+		}
+	}
+}
+
+func TestConfigVersionTenantsRedactsPlainSecrets(t *testing.T) {
+	saved := ConfigVersionsToKeep
+	ConfigVersionsToKeep = 5
+	t.Cleanup(func() { ConfigVersionsToKeep = saved })
+
+	path := filepath.Join(t.TempDir(), "tenants.json")
+	old := `[{"ID":"a","Name":"A","BearerToken":"plain","SimpleAuthToken":"${env:TOKEN}","OAuthCredentials":{"ClientSecret":"enc:v1:AAAA"}}]`
+	if err := writeTenantsFile(path, []byte(old)); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeTenantsFile(path, []byte("[]")); err != nil {
+		t.Fatal(err)
+	}
+	versions, err := ListConfigVersions(path)
+	if err != nil || len(versions) != 1 {
+		t.Fatalf("versions %v, %v; want one", versions, err)
+	}
+	tenants, err := ConfigVersionTenants(path, versions[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := tenants[0]
+	if a.BearerToken != redactedValue || a.SimpleAuthToken != "${env:TOKEN}" || a.OAuthCredentials.ClientSecret != "enc:v1:AAAA" {
+		t.Errorf("got BearerToken %q, SimpleAuthToken %q, ClientSecret %q", a.BearerToken, a.SimpleAuthToken, a.OAuthCredentials.ClientSecret)
+	}
+}
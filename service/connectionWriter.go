@@ -0,0 +1,87 @@
+package service
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"tcp_sandbox/domain"
+)
+
+// -----------------------------------------------------------
+// Outbound Queue (one writer goroutine per connection)
+// -----------------------------------------------------------
+
+const (
+	defaultWriteTimeout      = 10 * time.Second
+	defaultOutboundQueueSize = 64
+)
+
+// newConnection wraps a connection accepted on the listen address endpoint; it counts as active
+// from now on. Queue size, write timeout and framing are taken from the tenant when the
+// connection is accepted.
+func newConnection(t *domain.Tenant, endpoint string, conn net.Conn) *domain.Connection {
+	queueSize := t.OutboundQueueSize
+	if queueSize <= 0 {
+		queueSize = defaultOutboundQueueSize
+	}
+	writeTimeout := time.Duration(t.WriteTimeoutSec) * time.Second
+	if writeTimeout <= 0 {
+		writeTimeout = defaultWriteTimeout
+	}
+
+	now := time.Now()
+	c := &domain.Connection{
+		Conn:         conn,
+		Endpoint:     endpoint,
+		ConnectedAt:  now,
+		LastActivity: now.UnixNano(),
+		Outbound:     make(chan []byte, queueSize),
+		WriteTimeout: writeTimeout,
+		ReaderDone:   make(chan struct{}),
+		Closed:       make(chan struct{}),
+	}
+	c.Framing.Store(tenantFraming(t, endpoint))
+	return c
+}
+
+// sendFrame queues data for the connection's writer without blocking. A client whose queue
+// is full is too slow to keep up and gets disconnected.
+func sendFrame(t *domain.Tenant, c *domain.Connection, data []byte) bool {
+	atomic.AddInt64(&c.Pending, 1)
+	select {
+	case c.Outbound <- data:
+		return true
+	default:
+	}
+	atomic.AddInt64(&c.Pending, -1)
+
+	logError(t, fmt.Errorf("outbound queue of %s full (%d frames), disconnecting slow client", c.Conn.RemoteAddr(), cap(c.Outbound)))
+	c.Conn.Close()
+	return false
+}
+
+// runConnectionWriter writes queued frames until the connection handler exits.
+// A failed or timed out write closes the connection.
+func runConnectionWriter(t *domain.Tenant, c *domain.Connection) {
+	for {
+		select {
+		case <-c.Closed:
+			return
+		case data := <-c.Outbound:
+			c.Conn.SetWriteDeadline(time.Now().Add(c.WriteTimeout))
+			n, err := c.Conn.Write(data)
+			atomic.AddUint64(&t.BytesSent, uint64(n))
+			atomic.AddInt64(&c.Pending, -1)
+			if err != nil {
+				logError(t, fmt.Errorf("write error to %s: %v", c.Conn.RemoteAddr(), err))
+				log.Printf("[WARN][Tenant %q] Closing connection %s after failed write", t.Name, c.Conn.RemoteAddr())
+				c.Conn.Close()
+				return
+			}
+			touchConnection(c)
+		}
+	}
+}
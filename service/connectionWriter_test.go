@@ -0,0 +1,78 @@
+package service
+
+import (
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"tcp_sandbox/domain"
+)
+
+// newWriterTestConnection returns a connection without reader or writer, and its client side.
+func newWriterTestConnection(t *testing.T, tenant *domain.Tenant) (*domain.Connection, net.Conn) {
+	server, client := net.Pipe()
+	t.Cleanup(func() {
+		server.Close()
+		client.Close()
+	})
+	return newConnection(tenant, "test", server), client
+}
+
+// expectClosed fails unless the server side of client is closed within a second.
+func expectClosed(t *testing.T, client net.Conn) {
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := client.Read(make([]byte, 16)); err != io.EOF {
+		t.Errorf("connection not closed (read: %v)", err)
+	}
+}
+
+func TestFullOutboundQueueDisconnects(t *testing.T) {
+	tenant := &domain.Tenant{TenantConfig: domain.TenantConfig{ID: "slow", Name: "slow", OutboundQueueSize: 2}}
+	c, client := newWriterTestConnection(t, tenant)
+
+	for i := 0; i < 2; i++ {
+		if !sendFrame(tenant, c, []byte("frame")) {
+			t.Fatalf("frame %d rejected with room in the queue", i+1)
+		}
+	}
+	if sendFrame(tenant, c, []byte("frame")) {
+		t.Fatal("frame accepted into a full queue")
+	}
+	if n := atomic.LoadInt64(&c.Pending); n != 2 {
+		t.Errorf("Pending = %d, want the 2 queued frames", n)
+	}
+	if n := atomic.LoadUint64(&tenant.Errors); n != 1 {
+		t.Errorf("Errors = %d, want 1", n)
+	}
+	expectClosed(t, client)
+}
+
+func TestWriteDeadlineDisconnects(t *testing.T) {
+	tenant := &domain.Tenant{TenantConfig: domain.TenantConfig{ID: "stuck", Name: "stuck"}}
+	c, client := newWriterTestConnection(t, tenant)
+	c.WriteTimeout = 100 * time.Millisecond
+
+	done := make(chan struct{})
+	go func() {
+		runConnectionWriter(tenant, c)
+		close(done)
+	}()
+
+	// the client never reads, so the write cannot complete
+	start := time.Now()
+	sendFrame(tenant, c, []byte("frame"))
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("writer still blocked after the write timeout")
+	}
+	if d := time.Since(start); d < 100*time.Millisecond {
+		t.Errorf("writer gave up after %s, before the write timeout", d)
+	}
+	if n := atomic.LoadUint64(&tenant.Errors); n != 1 {
+		t.Errorf("Errors = %d, want 1", n)
+	}
+	expectClosed(t, client)
+}
@@ -5,15 +5,15 @@ import (
 	"bytes"
 	"encoding/json"
 	"encoding/xml"
+	"errors"
 	"fmt"
 	"io"
 	"log"
-	"net"
 	"net/http"
-	"net/url"
 	"strings"
 	"sync/atomic"
 	"tcp_sandbox/domain"
+	"tcp_sandbox/globals"
 	"time"
 )
 
@@ -21,18 +21,37 @@ import (
 // Handle Connection & Message Flow
 // -----------------------------------------------------------
 
-func handleConnection(conn net.Conn, t *domain.Tenant) {
+func handleConnection(c *domain.Connection, t *domain.Tenant) {
+	conn := c.Conn
 	defer func() {
-		removeConnection(t, conn)
-		conn.Close()
+		close(c.ReaderDone)
+		if !isDraining(c) {
+			closeConnection(t, c)
+		}
 	}()
 	reader := bufio.NewReader(conn)
 	var buffer []byte
 	inMessage := false
+	framing := connFraming(c)
 
 	for {
+		if !inMessage && isDraining(c) {
+			return
+		}
 		b, err := reader.ReadByte()
 		if err != nil {
+			if isDraining(c) {
+				// finish a started frame, up to the drain deadline
+				deadline := time.Unix(0, atomic.LoadInt64(&c.DrainDeadline))
+				if inMessage && isTimeout(err) && time.Now().Before(deadline) {
+					conn.SetReadDeadline(deadline)
+					continue
+				}
+				if inMessage {
+					log.Printf("[WARN][Tenant %q] Dropping incomplete frame from %s at drain deadline", t.Name, conn.RemoteAddr())
+				}
+				return
+			}
 			if err == io.EOF {
 				log.Printf("Tenant %q client disconnected: %s\n", t.Name, conn.RemoteAddr())
 			} else {
@@ -42,25 +61,25 @@ func handleConnection(conn net.Conn, t *domain.Tenant) {
 		}
 
 		atomic.AddUint64(&t.BytesReceived, 1)
+		touchConnection(c)
+		if !inMessage {
+			framing = nextFraming(t, c)
+		}
 
 		switch b {
-		case t.StartByte:
+		case framing.StartByte:
 			buffer = buffer[:0]
 			inMessage = true
-		case t.EndByte:
+		case framing.EndByte:
 			if inMessage {
 				inMessage = false
 				message := string(buffer)
-				log.Printf("Received from tenant %q: %s", t.Name, message)
-				go handleCompleteMessage(t, message)
-
-				response := append([]byte{t.StartByte}, append(buffer, t.EndByte)...)
-				n, writeErr := conn.Write(response)
-				if writeErr != nil {
-					logError(t, fmt.Errorf("write error to %s: %v", conn.RemoteAddr(), writeErr))
-				} else {
-					atomic.AddUint64(&t.BytesSent, uint64(n))
+				if handleKeepAliveReply(t, c, message) || handleHeartbeat(t, c, message) {
+					break
 				}
+				forwardMessage(t, message)
+
+				sendFrame(t, c, frame(c, buffer))
 			}
 		default:
 			if inMessage {
@@ -72,22 +91,84 @@ func handleConnection(conn net.Conn, t *domain.Tenant) {
 	}
 }
 
-// handleCompleteMessage is called for each received message.
-func handleCompleteMessage(t *domain.Tenant, msg string) {
+// upstreamConfig is the part of a tenant's config forwarding a message needs, copied under
+// globals.TenantsLock so the upstream call runs without it while reloads and patches rewrite the tenant.
+type upstreamConfig struct {
+	TenantName    string
+	Endpoint      string
+	MessageFormat string
+
+	AuthType         string // effective type, see upstreamAuthType
+	SimpleAuthToken  string
+	BasicAuth        *domain.BasicAuthCredentials
+	APIKey           *domain.APIKeyCredentials
+	BearerToken      string
+	ExtraHeaders     map[string]string
+	OAuthCredentials domain.OAuthCredentials
+	AuthRetryOn403   bool
+}
+
+// upstreamConfigOf snapshots the upstream config. Caller holds globals.TenantsLock.
+// Pointers, maps and slices are copied too: secret handling updates them in place.
+func upstreamConfigOf(t *domain.Tenant) upstreamConfig {
+	cfg := upstreamConfig{
+		TenantName:       t.Name,
+		Endpoint:         t.Endpoint,
+		MessageFormat:    t.MessageFormat,
+		AuthType:         upstreamAuthType(t),
+		SimpleAuthToken:  t.SimpleAuthToken,
+		BearerToken:      t.BearerToken,
+		OAuthCredentials: t.OAuthCredentials,
+		AuthRetryOn403:   t.AuthRetryOn403,
+	}
+	if t.BasicAuth != nil {
+		basic := *t.BasicAuth
+		cfg.BasicAuth = &basic
+	}
+	if t.APIKey != nil {
+		key := *t.APIKey
+		cfg.APIKey = &key
+	}
+	if t.ExtraHeaders != nil {
+		cfg.ExtraHeaders = make(map[string]string, len(t.ExtraHeaders))
+		for name, value := range t.ExtraHeaders {
+			cfg.ExtraHeaders[name] = value
+		}
+	}
+	cfg.OAuthCredentials.Scopes = append([]string(nil), t.OAuthCredentials.Scopes...)
+	return cfg
+}
+
+// forwardMessage hands a received message to the upstream, with the tenant's config as it is now.
+func forwardMessage(t *domain.Tenant, msg string) {
+	globals.TenantsLock.Lock()
+	cfg := upstreamConfigOf(t)
+	globals.TenantsLock.Unlock()
+
+	atomic.AddUint64(&t.Messages, 1)
+	log.Printf("Received from tenant %q: %s", cfg.TenantName, msg)
+	atomic.AddInt64(&t.InFlight, 1)
+	go handleCompleteMessage(t, cfg, msg)
+}
+
+// handleCompleteMessage is called for each received message. The caller counts it in t.InFlight.
+// Only t's counters are used; the config comes from cfg.
+func handleCompleteMessage(t *domain.Tenant, cfg upstreamConfig, msg string) {
+	defer atomic.AddInt64(&t.InFlight, -1)
 
 	var body []byte
 	var contentType string
 
-	switch strings.ToLower(t.MessageFormat) {
+	switch strings.ToLower(cfg.MessageFormat) {
 	case "json":
 		// Construct a map and encode as JSON TODO
 		bodyMap := map[string]string{
-			"tenant":  t.Name,
+			"tenant":  cfg.TenantName,
 			"message": msg,
 		}
 		jsonBody, err := json.Marshal(bodyMap)
 		if err != nil {
-			logError(t, fmt.Errorf("json marshal error: %w", err))
+			logErrorFor(t, cfg.TenantName, fmt.Errorf("json marshal error: %w", err))
 			return
 		}
 		body = jsonBody
@@ -100,11 +181,11 @@ func handleCompleteMessage(t *domain.Tenant, msg string) {
 			Tenant  string   `xml:"Tenant"`
 			Content string   `xml:"Content"`
 		}
-		xm := XMLMessage{Tenant: t.Name, Content: msg}
+		xm := XMLMessage{Tenant: cfg.TenantName, Content: msg}
 
 		xmlBody, err := xml.Marshal(xm)
 		if err != nil {
-			logError(t, fmt.Errorf("xml marshal error: %w", err))
+			logErrorFor(t, cfg.TenantName, fmt.Errorf("xml marshal error: %w", err))
 			return
 		}
 		body = xmlBody
@@ -119,111 +200,115 @@ func handleCompleteMessage(t *domain.Tenant, msg string) {
 	default:
 		// Fallback to JSON if format not recognized TODO should be plain/text ?
 		bodyMap := map[string]string{
-			"tenant":  t.Name,
+			"tenant":  cfg.TenantName,
 			"message": msg,
 		}
 		jsonBody, err := json.Marshal(bodyMap)
 		if err != nil {
-			logError(t, fmt.Errorf("json marshal error: %w", err))
+			logErrorFor(t, cfg.TenantName, fmt.Errorf("json marshal error: %w", err))
 			return
 		}
 		body = jsonBody
 		contentType = "application/json"
 	}
 
-	req, err := http.NewRequest("POST", t.Endpoint, bytes.NewReader(body))
-	if err != nil {
-		logError(t, fmt.Errorf("building request error: %w", err))
-		return
-	}
+	deliverMessage(&upstreamMessage{t: t, cfg: cfg, body: body, contentType: contentType})
+}
 
-	// Simple token vs. OAuth
-	if t.SimpleAuthToken != "" {
-		req.Header.Set("X-Auth", t.SimpleAuthToken)
-	} else {
-		accessToken, err := getOrRefreshToken(t)
+// upstreamMessage is an encoded message on its way to the tenant's endpoint.
+type upstreamMessage struct {
+	t           *domain.Tenant // for the counters only
+	cfg         upstreamConfig
+	body        []byte
+	contentType string
+}
+
+// sendMessage posts a message to its endpoint, retrying once with a new token if the upstream
+// rejects the OAuth token, and counts and logs the outcome. It reports true if the upstream
+// throttled the message; the endpoint is then paused and the caller queues the message.
+func sendMessage(l *endpointLimiter, m *upstreamMessage) (throttled bool) {
+	t, cfg := m.t, m.cfg
+	authRetried := false
+	for {
+		l.wait()
+
+		resp, tok, err := postToEndpoint(cfg, m.body, m.contentType)
 		if err != nil {
-			logError(t, fmt.Errorf("unable to get token for tenant %q: %w", t.Name, err))
-			return
+			var authErr *authError
+			if errors.As(err, &authErr) {
+				logAuthFailure(t, cfg.TenantName, err)
+			} else {
+				logUpstreamError(t, cfg.TenantName, err)
+			}
+			return false
 		}
-		req.Header.Set("Authorization", fmt.Sprintf("%s %s", t.OAuthCredentials.TokenType, accessToken))
-	}
+		resp.Body.Close()
 
-	// Set content type according to the chosen format
-	req.Header.Set("Content-Type", contentType)
+		if isThrottleStatus(resp.StatusCode) {
+			atomic.AddUint64(&t.Throttled, 1)
+			until := l.pause(retryAfterDelay(resp.Header.Get("Retry-After"), time.Now()))
+			log.Printf("[WARN][Tenant %q] %s responded with status %d; pausing endpoint until %s and queueing the message",
+				cfg.TenantName, cfg.Endpoint, resp.StatusCode, until.Format(time.RFC3339))
+			return true
+		}
 
-	client := &http.Client{Timeout: 5 * time.Second}
-	resp, err := client.Do(req)
-	if err != nil {
-		logError(t, fmt.Errorf("REST call error: %w", err))
-		return
-	}
-	defer resp.Body.Close()
+		if isAuthFailureStatus(cfg, resp.StatusCode) {
+			if tok != nil && !authRetried {
+				// The upstream may have revoked the token before its expiry
+				authRetried = true // AuthFailures counts the outcome, not this attempt
+				invalidateToken(cfg.OAuthCredentials, *tok)
+				log.Printf("[WARN][Tenant %q] %s rejected the OAuth token with status %d; fetching a new token and retrying once",
+					cfg.TenantName, cfg.Endpoint, resp.StatusCode)
+				continue
+			}
+			logAuthFailure(t, cfg.TenantName, fmt.Errorf("REST call rejected credentials with status %d", resp.StatusCode))
+			return false
+		}
 
-	if resp.StatusCode < 200 || resp.StatusCode > 299 {
-		logError(t, fmt.Errorf("REST call responded with status %d", resp.StatusCode))
-		return
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			logUpstreamError(t, cfg.TenantName, fmt.Errorf("REST call responded with status %d", resp.StatusCode))
+			return false
+		}
+		log.Printf("[Tenant %q] REST call to %s succeeded. Status: %d",
+			cfg.TenantName, cfg.Endpoint, resp.StatusCode)
+		return false
 	}
-	log.Printf("[Tenant %q] REST call to %s succeeded. Status: %d",
-		t.Name, t.Endpoint, resp.StatusCode)
 }
 
-// getOrRefreshToken checks if our token is still valid for at least 10 seconds. TODO handle failed or wrong token
-func getOrRefreshToken(t *domain.Tenant) (string, error) {
-	now := time.Now()
-	if t.OAuthCredentials.TokenExpiry.After(now.Add(10 * time.Second)) {
-		return t.OAuthCredentials.AccessToken, nil
-	}
+// authError marks failures to obtain upstream credentials (e.g. the token endpoint is down).
+type authError struct {
+	err error
+}
 
-	data := url.Values{}
-	data.Set("grant_type", "client_credentials")
-	data.Set("client_id", t.OAuthCredentials.ClientID)
-	data.Set("client_secret", t.OAuthCredentials.ClientSecret)
-	if len(t.OAuthCredentials.Scopes) > 0 {
-		data.Set("scope", strings.Join(t.OAuthCredentials.Scopes, " "))
-	}
+func (e *authError) Error() string { return e.err.Error() }
+func (e *authError) Unwrap() error { return e.err }
 
-	req, err := http.NewRequest("POST", t.OAuthCredentials.TokenURL, strings.NewReader(data.Encode()))
-	if err != nil {
-		return "", err
-	}
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+// isAuthFailureStatus reports whether the upstream rejected our credentials.
+// 403 only counts when the tenant opted in, since it often means "not allowed" rather than "bad token".
+func isAuthFailureStatus(cfg upstreamConfig, status int) bool {
+	return status == http.StatusUnauthorized || (status == http.StatusForbidden && cfg.AuthRetryOn403)
+}
 
-	client := &http.Client{Timeout: 5 * time.Second}
-	resp, err := client.Do(req)
+// postToEndpoint sends one message body to the tenant's endpoint. The caller closes the response body.
+// If an OAuth token was used it is returned, so the caller can invalidate it when the upstream rejects it.
+func postToEndpoint(cfg upstreamConfig, body []byte, contentType string) (*http.Response, *oauthToken, error) {
+	req, err := http.NewRequest("POST", cfg.Endpoint, bytes.NewReader(body))
 	if err != nil {
-		return "", fmt.Errorf("error requesting new token: %w", err)
+		return nil, nil, fmt.Errorf("building request error: %w", err)
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode < 200 || resp.StatusCode > 299 {
-		return "", fmt.Errorf("token endpoint returned status %d", resp.StatusCode)
-	}
-
-	var tokenResp struct {
-		AccessToken string `json:"access_token"`
-		ExpiresIn   int64  `json:"expires_in"` // in seconds
-		TokenType   string `json:"token_type"`
-	}
-	dec := json.NewDecoder(resp.Body)
-	if err := dec.Decode(&tokenResp); err != nil {
-		return "", fmt.Errorf("invalid token response: %w", err)
-	}
+	// Set content type according to the chosen format
+	req.Header.Set("Content-Type", contentType)
 
-	t.OAuthCredentials.AccessToken = tokenResp.AccessToken
-	if tokenResp.TokenType != "" {
-		t.OAuthCredentials.TokenType = tokenResp.TokenType
-	} else {
-		t.OAuthCredentials.TokenType = "Bearer"
+	usedToken, err := applyUpstreamAuth(req, cfg)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	if tokenResp.ExpiresIn > 0 {
-		t.OAuthCredentials.TokenExpiry = now.Add(time.Second * time.Duration(tokenResp.ExpiresIn))
-	} else {
-		t.OAuthCredentials.TokenExpiry = now.Add(1 * time.Hour)
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("REST call error: %w", err)
 	}
-
-	log.Printf("[Tenant %q] New OAuth token (%s) expires at %v",
-		t.Name, t.OAuthCredentials.TokenType, t.OAuthCredentials.TokenExpiry)
-	return t.OAuthCredentials.AccessToken, nil
+	return resp, usedToken, nil
 }
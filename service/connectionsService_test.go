@@ -0,0 +1,136 @@
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"tcp_sandbox/domain"
+	"tcp_sandbox/globals"
+)
+
+// newOAuthTenant returns a tenant posting to an endpoint that answers with the given statuses in
+// turn (the last one repeated), with tokens from a local token endpoint.
+func newOAuthTenant(t *testing.T, statuses ...int) (*domain.Tenant, *int32) {
+	var tokens int32
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&tokens, 1)
+		fmt.Fprintf(w, `{"access_token": "token-%d", "expires_in": 3600}`, n)
+	}))
+	t.Cleanup(tokenServer.Close)
+
+	var calls int32
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := int(atomic.AddInt32(&calls, 1))
+		if n > len(statuses) {
+			n = len(statuses)
+		}
+		w.WriteHeader(statuses[n-1])
+	}))
+	t.Cleanup(endpoint.Close)
+
+	tenant := &domain.Tenant{TenantConfig: domain.TenantConfig{
+		ID:            t.Name(),
+		Name:          t.Name(),
+		Endpoint:      endpoint.URL,
+		MessageFormat: "text",
+		OAuthCredentials: domain.OAuthCredentials{
+			ClientID: t.Name(), // keeps the cached token apart from other tests
+			TokenURL: tokenServer.URL,
+		},
+	}}
+	return tenant, &calls
+}
+
+func TestAuthFailureCountsOnlyFinalOutcome(t *testing.T) {
+	for _, tc := range []struct {
+		name         string
+		statuses     []int
+		wantCalls    int32
+		authFailures uint64
+	}{
+		{"rejected twice", []int{401}, 2, 1},
+		{"accepted after new token", []int{401, 200}, 2, 0},
+		{"accepted", []int{200}, 1, 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tenant, calls := newOAuthTenant(t, tc.statuses...)
+			atomic.AddInt64(&tenant.InFlight, 1)
+			handleCompleteMessage(tenant, upstreamConfigOf(tenant), "hello")
+
+			if got := atomic.LoadInt32(calls); got != tc.wantCalls {
+				t.Errorf("endpoint called %d times, want %d", got, tc.wantCalls)
+			}
+			if tenant.AuthFailures != tc.authFailures {
+				t.Errorf("AuthFailures = %d, want %d", tenant.AuthFailures, tc.authFailures)
+			}
+			if tenant.InFlight != 0 {
+				t.Errorf("InFlight = %d after the call", tenant.InFlight)
+			}
+		})
+	}
+}
+
+// Run with -race: forwarded messages must only see the tenant's config through their snapshot,
+// while reloads and patches replace it the way mergeTenants does.
+func TestForwardMessageDuringConfigRewrites(t *testing.T) {
+	var calls int32
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-API-Key") == "" || r.Header.Get("X-Extra") == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		atomic.AddInt32(&calls, 1)
+	}))
+	defer endpoint.Close()
+
+	configAt := func(i int) domain.TenantConfig {
+		return domain.TenantConfig{
+			ID:            t.Name(),
+			Name:          fmt.Sprintf("%s-%d", t.Name(), i),
+			Endpoint:      endpoint.URL,
+			MessageFormat: []string{"json", "xml", "text"}[i%3],
+			AuthType:      "apikey",
+			APIKey:        &domain.APIKeyCredentials{Name: "X-API-Key", Value: fmt.Sprintf("key-%d", i)},
+			ExtraHeaders:  map[string]string{"X-Extra": fmt.Sprint(i)},
+		}
+	}
+	tenant := &domain.Tenant{TenantConfig: configAt(0)}
+
+	const messages = 50
+	stop := make(chan struct{})
+	rewritten := make(chan struct{})
+	go func() {
+		defer close(rewritten)
+		for i := 1; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			globals.TenantsLock.Lock()
+			tenant.TenantConfig = configAt(i)
+			globals.TenantsLock.Unlock()
+		}
+	}()
+	for i := 0; i < messages; i++ {
+		forwardMessage(tenant, "hello")
+		time.Sleep(time.Millisecond) // let rewrites interleave with the upstream calls
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt64(&tenant.InFlight) > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	close(stop)
+	<-rewritten
+	if got := atomic.LoadInt32(&calls); got != messages {
+		t.Errorf("endpoint accepted %d messages, want %d", got, messages)
+	}
+	if tenant.Errors != 0 {
+		t.Errorf("Errors = %d, want 0", tenant.Errors)
+	}
+}
@@ -0,0 +1,224 @@
+package service
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"tcp_sandbox/domain"
+)
+
+// -----------------------------------------------------------
+// Encrypted Secrets (AES-256-GCM with a master key)
+// -----------------------------------------------------------
+
+const (
+	// EncryptedPrefix marks an encrypted config value: "enc:v1:" + base64(nonce | ciphertext).
+	EncryptedPrefix = "enc:v1:"
+
+	// MasterKeyEnv holds the base64 (or hex) encoded 32 byte master key.
+	MasterKeyEnv = "TCP_SANDBOX_MASTER_KEY"
+	// MasterKeyFileEnv points to a file holding the master key, used if MasterKeyEnv is not set.
+	MasterKeyFileEnv = "TCP_SANDBOX_MASTER_KEY_FILE"
+)
+
+var errNoMasterKey = fmt.Errorf("no master key configured (set %s or %s)", MasterKeyEnv, MasterKeyFileEnv)
+
+// LoadMasterKey returns the configured master key, or nil if none is configured.
+func LoadMasterKey() ([]byte, error) {
+	if v, ok := os.LookupEnv(MasterKeyEnv); ok && v != "" {
+		return parseMasterKey([]byte(v))
+	}
+	if path := os.Getenv(MasterKeyFileEnv); path != "" {
+		return LoadMasterKeyFile(path)
+	}
+	return nil, nil
+}
+
+// LoadMasterKeyFile reads a master key from a file.
+func LoadMasterKeyFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading master key file: %w", err)
+	}
+	return parseMasterKey(data)
+}
+
+// parseMasterKey accepts a base64 or hex encoded key, or 32 raw bytes.
+func parseMasterKey(data []byte) ([]byte, error) {
+	s := strings.TrimSpace(string(data))
+	if key, err := base64.StdEncoding.DecodeString(s); err == nil && len(key) == 32 {
+		return key, nil
+	}
+	if key, err := hex.DecodeString(s); err == nil && len(key) == 32 {
+		return key, nil
+	}
+	if len(data) == 32 {
+		return data, nil
+	}
+	return nil, errors.New("master key must be 32 bytes (base64 or hex encoded)")
+}
+
+// isEncryptedValue reports whether a config value is in the encrypted format.
+func isEncryptedValue(v string) bool {
+	return strings.HasPrefix(v, EncryptedPrefix)
+}
+
+// EncryptSecret encrypts a plain value into the config file format.
+func EncryptSecret(key []byte, plaintext string) (string, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nonce, nonce, []byte(plaintext), nil)
+	return EncryptedPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// decryptSecret reverses EncryptSecret.
+func decryptSecret(key []byte, value string) (string, error) {
+	if key == nil {
+		return "", errNoMasterKey
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, EncryptedPrefix))
+	if err != nil {
+		return "", fmt.Errorf("invalid encrypted value: %w", err)
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return "", errors.New("invalid encrypted value: too short")
+	}
+	plain, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", errors.New("cannot decrypt value (wrong master key?)")
+	}
+	return string(plain), nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encryptPlainSecrets encrypts the tenant's plain secret fields for saving and remembers the
+// ciphertext as the field's reference, so repeated saves write the same value.
+func encryptPlainSecrets(t *domain.Tenant, key []byte) error {
+	for _, f := range secretFields(t) {
+		if _, ok := t.SecretRefs[f.Path]; ok || f.Get() == "" {
+			continue
+		}
+		enc, err := EncryptSecret(key, f.Get())
+		if err != nil {
+			return fmt.Errorf("tenant %q field %s: %w", t.Name, f.Path, err)
+		}
+		if t.SecretRefs == nil {
+			t.SecretRefs = make(map[string]string)
+		}
+		t.SecretRefs[f.Path] = enc
+	}
+	return nil
+}
+
+// RotateMasterKey re-encrypts every encrypted value in the tenants file and its kept versions with
+// newKey, so rolling back after the rotation still works. A version that cannot be re-encrypted
+// (e.g. written with an even older key) is deleted with a warning, since it could not be rolled
+// back to anymore. It returns the number of re-encrypted values in the tenants file.
+func RotateMasterKey(filename string, oldKey, newKey []byte) (int, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return 0, err
+	}
+	out, count, err := rotateTenantsData(data, oldKey, newKey)
+	if err != nil {
+		return 0, err
+	}
+	// The file is archived with the old key first, and re-encrypted with the other versions below
+	if err := writeTenantsFile(filename, out); err != nil {
+		return 0, err
+	}
+	return count, rotateConfigVersions(filename, oldKey, newKey)
+}
+
+// rotateConfigVersions re-encrypts the kept versions of the tenants file with newKey.
+func rotateConfigVersions(filename string, oldKey, newKey []byte) error {
+	tenantsFileWriteLock.Lock()
+	defer tenantsFileWriteLock.Unlock()
+
+	versions, err := ListConfigVersions(filename)
+	if err != nil {
+		return err
+	}
+	for _, v := range versions {
+		path := versionPath(filename, v.ID)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		out, count, err := rotateTenantsData(data, oldKey, newKey)
+		if err != nil {
+			log.Printf("[WARN] Deleting config version %s of %s: it cannot be re-encrypted with the new master key (%v)", v.ID, filename, err)
+			if err := os.Remove(path); err != nil {
+				return err
+			}
+			continue
+		}
+		if count == 0 {
+			continue
+		}
+		if err := writeFileAtomic(path, out, 0600); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rotateTenantsData re-encrypts the encrypted values of a tenants file's content with newKey.
+// It returns the new content and the number of re-encrypted values.
+func rotateTenantsData(data []byte, oldKey, newKey []byte) ([]byte, int, error) {
+	var tenants []*domain.Tenant
+	if err := json.Unmarshal(data, &tenants); err != nil {
+		return nil, 0, fmt.Errorf("json unmarshal error: %w", err)
+	}
+
+	count := 0
+	for _, t := range tenants {
+		for _, f := range secretFields(t) {
+			if !isEncryptedValue(f.Get()) {
+				continue
+			}
+			plain, err := decryptSecret(oldKey, f.Get())
+			if err != nil {
+				return nil, 0, fmt.Errorf("tenant %q field %s: %w", t.Name, f.Path, err)
+			}
+			enc, err := EncryptSecret(newKey, plain)
+			if err != nil {
+				return nil, 0, err
+			}
+			f.Set(enc)
+			count++
+		}
+	}
+
+	out, err := json.MarshalIndent(tenants, "", "  ")
+	if err != nil {
+		return nil, 0, fmt.Errorf("json marshal error: %w", err)
+	}
+	return out, count, nil
+}
@@ -0,0 +1,204 @@
+package service
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"tcp_sandbox/domain"
+	"tcp_sandbox/globals"
+)
+
+func testMasterKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, 32)
+}
+
+func TestParseMasterKey(t *testing.T) {
+	key := testMasterKey(0xab)
+	for _, in := range []string{
+		base64.StdEncoding.EncodeToString(key),
+		base64.StdEncoding.EncodeToString(key) + "\n",
+		hex.EncodeToString(key),
+		string(key),
+	} {
+		got, err := parseMasterKey([]byte(in))
+		if err != nil || !bytes.Equal(got, key) {
+			t.Errorf("parseMasterKey(%q) = %x, %v", in, got, err)
+		}
+	}
+	for _, in := range []string{"", "short", base64.StdEncoding.EncodeToString(key[:16]), hex.EncodeToString(key[:31])} {
+		if _, err := parseMasterKey([]byte(in)); err == nil {
+			t.Errorf("parseMasterKey(%q) accepted", in)
+		}
+	}
+}
+
+func TestEncryptSecretRoundTrip(t *testing.T) {
+	key := testMasterKey(1)
+	a, err := EncryptSecret(key, "s3cret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := EncryptSecret(key, "s3cret")
+	if !strings.HasPrefix(a, EncryptedPrefix) || !isEncryptedValue(a) {
+		t.Errorf("%q lacks the %s prefix", a, EncryptedPrefix)
+	}
+	if a == b {
+		t.Errorf("same ciphertext twice, the nonce is not random")
+	}
+	for _, enc := range []string{a, b} {
+		if plain, err := decryptSecret(key, enc); err != nil || plain != "s3cret" {
+			t.Errorf("decryptSecret(%q) = %q, %v", enc, plain, err)
+		}
+	}
+
+	sealed, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(a, EncryptedPrefix))
+	sealed[len(sealed)-1] ^= 1
+	tampered := EncryptedPrefix + base64.StdEncoding.EncodeToString(sealed)
+
+	for name, tc := range map[string]struct {
+		key   []byte
+		value string
+	}{
+		"wrong key":   {testMasterKey(2), a},
+		"no key":      {nil, a},
+		"tampered":    {key, tampered},
+		"not base64":  {key, EncryptedPrefix + "!!!"},
+		"too short":   {key, EncryptedPrefix + base64.StdEncoding.EncodeToString([]byte("abc"))},
+		"invalid key": {key[:7], a},
+	} {
+		if plain, err := decryptSecret(tc.key, tc.value); err == nil {
+			t.Errorf("%s: decrypted to %q", name, plain)
+		}
+	}
+	if _, err := decryptSecret(nil, a); !errors.Is(err, errNoMasterKey) {
+		t.Errorf("without a key: %v, want errNoMasterKey", err)
+	}
+}
+
+func TestResolveEncryptedSecret(t *testing.T) {
+	key := testMasterKey(3)
+	t.Setenv(MasterKeyEnv, base64.StdEncoding.EncodeToString(key))
+	enc, err := EncryptSecret(key, "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tenant := &domain.Tenant{TenantConfig: domain.TenantConfig{Name: "A", BearerToken: enc}}
+	if err := ResolveSecretRefs(tenant); err != nil {
+		t.Fatal(err)
+	}
+	if tenant.BearerToken != "token" || tenant.SecretRefs["BearerToken"] != enc {
+		t.Errorf("BearerToken %q, reference %q; want the plain value and the ciphertext kept", tenant.BearerToken, tenant.SecretRefs["BearerToken"])
+	}
+
+	t.Setenv(MasterKeyEnv, base64.StdEncoding.EncodeToString(testMasterKey(4)))
+	tenant = &domain.Tenant{TenantConfig: domain.TenantConfig{Name: "A", BearerToken: enc}}
+	if err := ResolveSecretRefs(tenant); err == nil {
+		t.Errorf("resolved with the wrong master key")
+	}
+}
+
+func TestRotateMasterKey(t *testing.T) {
+	oldKey, newKey := testMasterKey(5), testMasterKey(6)
+	token, _ := EncryptSecret(oldKey, "token")
+	secret, _ := EncryptSecret(oldKey, "secret")
+	tenants := []domain.TenantConfig{{
+		ID: "a", Name: "A", BearerToken: token, Comment: "plain",
+		OAuthCredentials: domain.OAuthCredentials{ClientSecret: secret},
+	}}
+	data, _ := json.Marshal(tenants)
+	path := filepath.Join(t.TempDir(), "tenants.json")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := RotateMasterKey(path, oldKey, newKey)
+	if err != nil || n != 2 {
+		t.Fatalf("RotateMasterKey = %d, %v; want 2 values", n, err)
+	}
+	data, _ = os.ReadFile(path)
+	var got []domain.TenantConfig
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if plain, err := decryptSecret(newKey, got[0].BearerToken); err != nil || plain != "token" {
+		t.Errorf("BearerToken with the new key: %q, %v", plain, err)
+	}
+	if plain, err := decryptSecret(newKey, got[0].OAuthCredentials.ClientSecret); err != nil || plain != "secret" {
+		t.Errorf("ClientSecret with the new key: %q, %v", plain, err)
+	}
+	if got[0].Comment != "plain" {
+		t.Errorf("other fields changed: %+v", got[0])
+	}
+
+	// with the wrong old key nothing is written
+	before, _ := os.ReadFile(path)
+	if _, err := RotateMasterKey(path, oldKey, newKey); err == nil {
+		t.Errorf("rotated with the wrong old key")
+	}
+	if after, _ := os.ReadFile(path); !bytes.Equal(before, after) {
+		t.Errorf("file changed by a failed rotation")
+	}
+}
+
+func TestRotateMasterKeyThenRollback(t *testing.T) {
+	otherKey, oldKey, newKey := testMasterKey(7), testMasterKey(8), testMasterKey(9)
+	t.Setenv(MasterKeyEnv, base64.StdEncoding.EncodeToString(newKey))
+	withTenants(t)
+	t.Cleanup(func() {
+		globals.TenantsLock.Lock()
+		for _, tenant := range globals.Tenants {
+			closeTenantListeners(tenant)
+		}
+		globals.TenantsLock.Unlock()
+	})
+
+	// a free port; the validator does not accept port 0
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	path := filepath.Join(t.TempDir(), "tenants.json")
+	write := func(key []byte, token string) {
+		enc, _ := EncryptSecret(key, token)
+		if err := writeTenantsFile(path, []byte("["+validTenant("a", addr, `"BearerToken":"`+enc+`"`)+"]")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(otherKey, "token-0") // cannot be decrypted with the old key
+	write(oldKey, "token-1")
+	write(oldKey, "token-2")
+
+	if _, err := RotateMasterKey(path, oldKey, newKey); err != nil {
+		t.Fatalf("RotateMasterKey: %v", err)
+	}
+	versions, err := ListConfigVersions(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// token-2 (the file before the rotation) and token-1; token-0 is deleted
+	if len(versions) != 2 {
+		t.Fatalf("%d versions after the rotation, want 2", len(versions))
+	}
+
+	if err := RollbackConfig(path, versions[1].ID); err != nil {
+		t.Fatalf("rollback after the rotation: %v", err)
+	}
+	globals.TenantsLock.Lock()
+	got := globals.Tenants["a"].BearerToken
+	globals.TenantsLock.Unlock()
+	if got != "token-1" {
+		t.Errorf("BearerToken after the rollback = %q, want token-1", got)
+	}
+}
@@ -0,0 +1,64 @@
+//go:build linux
+
+package service
+
+import (
+	"log"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// watchFile reports changes of a file via inotify. The directory is watched rather than
+// the file, so editors that replace the file (write a copy, rename it over) are noticed too.
+// The channel is closed if watching fails later on.
+func watchFile(filename string) (<-chan struct{}, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+	dir, name := filepath.Dir(filename), filepath.Base(filename)
+	const mask = syscall.IN_CLOSE_WRITE | syscall.IN_MODIFY | syscall.IN_CREATE |
+		syscall.IN_MOVED_TO | syscall.IN_DELETE | syscall.IN_MOVED_FROM | syscall.IN_ATTRIB
+	if _, err := syscall.InotifyAddWatch(fd, dir, mask); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+
+	events := make(chan struct{}, 1)
+	go func() {
+		defer close(events)
+		defer syscall.Close(fd)
+
+		buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+		for {
+			n, err := syscall.Read(fd, buf)
+			if err == syscall.EINTR {
+				continue
+			}
+			if err != nil || n <= 0 {
+				log.Printf("[ERROR] inotify read on %s: %v", dir, err)
+				return
+			}
+
+			for off := 0; off+syscall.SizeofInotifyEvent <= n; {
+				ev := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[off]))
+				start := off + syscall.SizeofInotifyEvent
+				end := start + int(ev.Len)
+				off = end
+				if end > n {
+					break
+				}
+				if strings.TrimRight(string(buf[start:end]), "\x00") != name {
+					continue
+				}
+				select {
+				case events <- struct{}{}:
+				default: // a change is already pending
+				}
+			}
+		}
+	}()
+	return events, nil
+}
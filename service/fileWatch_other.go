@@ -0,0 +1,10 @@
+//go:build !linux
+
+package service
+
+import "errors"
+
+// watchFile is only implemented on Linux; elsewhere the tenant file is polled.
+func watchFile(filename string) (<-chan struct{}, error) {
+	return nil, errors.New("file events not supported on this platform")
+}
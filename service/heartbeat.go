@@ -0,0 +1,46 @@
+package service
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"tcp_sandbox/domain"
+)
+
+// -----------------------------------------------------------
+// Client Heartbeats (answered locally, never forwarded)
+// -----------------------------------------------------------
+
+// isHeartbeat reports whether a received frame is one of the tenant's client heartbeats.
+func isHeartbeat(t *domain.Tenant, msg string) bool {
+	if t.HeartbeatMatch != "" && msg == t.HeartbeatMatch {
+		return true
+	}
+	if t.HeartbeatPattern == "" {
+		return false
+	}
+	re, err := compileFramePattern(t.HeartbeatPattern)
+	if err != nil {
+		logError(t, fmt.Errorf("invalid HeartbeatPattern: %v", err))
+		return false
+	}
+	return re.MatchString(msg)
+}
+
+// handleHeartbeat answers a client heartbeat and marks the connection alive.
+// It reports false if msg is an ordinary message.
+func handleHeartbeat(t *domain.Tenant, c *domain.Connection, msg string) bool {
+	if !isHeartbeat(t, msg) {
+		return false
+	}
+	atomic.StoreInt64(&c.LastHeartbeat, time.Now().UnixNano())
+	atomic.AddUint64(&t.Heartbeats, 1)
+
+	reply := t.HeartbeatReply
+	if reply == "" {
+		reply = msg
+	}
+	sendFrame(t, c, frame(c, []byte(reply)))
+	return true
+}
@@ -0,0 +1,64 @@
+package service
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"tcp_sandbox/domain"
+)
+
+// roundTrip sends one frame and returns the server's answer.
+func roundTrip(t *testing.T, client net.Conn, frame string) string {
+	client.SetDeadline(time.Now().Add(2 * time.Second))
+	if _, err := client.Write([]byte(frame)); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 64)
+	n, err := client.Read(buf)
+	if err != nil {
+		t.Fatalf("no answer to %q: %v", frame, err)
+	}
+	return string(buf[:n])
+}
+
+func TestHeartbeatsAreAnsweredNotForwarded(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		config    domain.TenantConfig
+		heartbeat string
+		want      string
+	}{
+		{"match with reply", domain.TenantConfig{HeartbeatMatch: "HB", HeartbeatReply: "ACK"}, "HB", "ACK"},
+		{"pattern echoed", domain.TenantConfig{HeartbeatPattern: `^PING \d+$`}, "PING 42", "PING 42"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := tc.config
+			cfg.ID, cfg.Name, cfg.StartByte, cfg.EndByte, cfg.AuthType = "hb", "hb", 2, 3, "none"
+			tenant := &domain.Tenant{TenantConfig: cfg}
+			client := connectTestClient(t, tenant)
+
+			if got := roundTrip(t, client, "\x02"+tc.heartbeat+"\x03"); got != "\x02"+tc.want+"\x03" {
+				t.Errorf("heartbeat answered with %q, want %q", got, tc.want)
+			}
+			if n := atomic.LoadUint64(&tenant.Heartbeats); n != 1 {
+				t.Errorf("Heartbeats = %d, want 1", n)
+			}
+			if n := atomic.LoadUint64(&tenant.Messages); n != 0 {
+				t.Errorf("heartbeat counted as message (Messages = %d)", n)
+			}
+
+			// an ordinary frame is still forwarded (and echoed)
+			if got := roundTrip(t, client, "\x02hello\x03"); got != "\x02hello\x03" {
+				t.Errorf("message echoed as %q", got)
+			}
+			if n := atomic.LoadUint64(&tenant.Messages); n != 1 {
+				t.Errorf("Messages = %d after an ordinary frame, want 1", n)
+			}
+			if n := atomic.LoadUint64(&tenant.Heartbeats); n != 1 {
+				t.Errorf("Heartbeats = %d after an ordinary frame, want 1", n)
+			}
+		})
+	}
+}
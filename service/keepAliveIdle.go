@@ -0,0 +1,98 @@
+package service
+
+import (
+	"log"
+	"math/rand"
+	"sync/atomic"
+	"time"
+
+	"tcp_sandbox/domain"
+	"tcp_sandbox/globals"
+)
+
+// -----------------------------------------------------------
+// Idle Keep-Alives (KeepAliveMode "idle", one loop per connection)
+// -----------------------------------------------------------
+
+// runIdleKeepAlive probes one connection whenever it has been idle (no traffic in either
+// direction) for the tenant's keep-alive interval plus optional jitter. It runs for the
+// connection's lifetime and sleeps while the tenant is not in idle mode.
+func runIdleKeepAlive(t *domain.Tenant, c *domain.Connection) {
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+	defer atomic.StoreInt64(&c.NextKeepAlive, 0)
+
+	for {
+		changed := keepAliveChanged(t)
+
+		globals.TenantsLock.Lock()
+		active := keepAliveWanted(t) && isIdleKeepAlive(t)
+		cfg := keepAliveConfigOf(t)
+		interval := time.Duration(t.KeepAliveIntervalSec) * time.Second
+		jitter := time.Duration(t.KeepAliveJitterSec) * time.Second
+		globals.TenantsLock.Unlock()
+
+		if !active {
+			atomic.StoreInt64(&c.NextKeepAlive, 0)
+			select {
+			case <-c.Closed:
+				return
+			case <-changed:
+				continue
+			}
+		}
+
+		delay := interval
+		if jitter > 0 {
+			delay += time.Duration(rng.Int63n(int64(jitter)))
+		}
+		due := lastActivity(c).Add(delay)
+		atomic.StoreInt64(&c.NextKeepAlive, due.UnixNano())
+
+		timer := time.NewTimer(time.Until(due))
+		select {
+		case <-c.Closed:
+			timer.Stop()
+			return
+		case <-changed:
+			timer.Stop()
+			continue
+		case <-timer.C:
+		}
+
+		// Traffic since we computed the deadline postpones the probe
+		if time.Since(lastActivity(c)) < interval {
+			continue
+		}
+
+		t.ConnectionsLock.Lock()
+		connCount := len(t.Connections)
+		t.ConnectionsLock.Unlock()
+
+		payload, vars, err := renderKeepAlive(t, cfg, connCount)
+		if err != nil {
+			log.Printf("[ERROR][Tenant %q] Could not render keep-alive: %v", cfg.TenantName, err)
+			// Nothing was sent, so the connection is still idle: try again after a full
+			// delay (or a config change) instead of at once
+			atomic.StoreInt64(&c.NextKeepAlive, time.Now().Add(delay).UnixNano())
+			retry := time.NewTimer(delay)
+			select {
+			case <-c.Closed:
+				retry.Stop()
+				return
+			case <-changed:
+				retry.Stop()
+			case <-retry.C:
+			}
+			continue
+		}
+		writeKeepAlive(t, c, cfg, payload)
+		// The probe counts as traffic once queued; waiting for the writer to send it would
+		// probe again and again while the client is slow to read
+		touchConnection(c)
+		log.Printf("[Tenant %q] Idle keep-alive #%d sent to %s", cfg.TenantName, vars.Sequence, c.Conn.RemoteAddr())
+	}
+}
+
+func lastActivity(c *domain.Connection) time.Time {
+	return time.Unix(0, atomic.LoadInt64(&c.LastActivity))
+}
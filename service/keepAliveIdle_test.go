@@ -0,0 +1,93 @@
+package service
+
+import (
+	"io"
+	"net"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"tcp_sandbox/domain"
+)
+
+func newIdleTestTenant(keepAliveFile string) *domain.Tenant {
+	return &domain.Tenant{TenantConfig: domain.TenantConfig{
+		ID: "idle", Name: "idle", StartByte: 2, EndByte: 3,
+		KeepAliveMode: "idle", KeepAliveIntervalSec: 1, KeepAliveFormat: "text", KeepAliveFile: keepAliveFile,
+	}}
+}
+
+// startIdleKeepAlive connects a client and runs the connection's idle keep-alive loop.
+func startIdleKeepAlive(t *testing.T, tenant *domain.Tenant) (net.Conn, *domain.Connection) {
+	server, client := net.Pipe()
+	c := newConnection(tenant, "test", server)
+	addConnection(tenant, c)
+	go runIdleKeepAlive(tenant, c)
+	t.Cleanup(func() {
+		closeConnection(tenant, c)
+		client.Close()
+	})
+	return client, c
+}
+
+func TestIdleKeepAliveWaitsAfterRenderError(t *testing.T) {
+	tenant := newIdleTestTenant(filepath.Join(t.TempDir(), "missing.txt"))
+	_, c := startIdleKeepAlive(t, tenant)
+
+	time.Sleep(1500 * time.Millisecond)
+	// one attempt after the first second; without waiting it would retry at once, over and over
+	if n := atomic.LoadUint64(&tenant.KeepAliveSeq); n != 1 {
+		t.Errorf("%d render attempts in 1.5s, want 1", n)
+	}
+	if next := time.Unix(0, atomic.LoadInt64(&c.NextKeepAlive)); time.Until(next) < 300*time.Millisecond {
+		t.Errorf("next keep-alive at %s, want about a second from the failed attempt", next.Format(time.RFC3339Nano))
+	}
+}
+
+func TestIdleKeepAliveProbesIdleConnection(t *testing.T) {
+	tenant := newIdleTestTenant("")
+	tenant.KeepAliveMessage = "PING"
+	client, c := startIdleKeepAlive(t, tenant)
+	go runConnectionWriter(tenant, c)
+
+	start := time.Now()
+	buf := make([]byte, 16)
+	client.SetReadDeadline(time.Now().Add(3 * time.Second))
+	n, err := client.Read(buf)
+	if err != nil {
+		t.Fatalf("no keep-alive on an idle connection: %v", err)
+	}
+	if got := string(buf[:n]); got != "\x02PING\x03" {
+		t.Errorf("keep-alive %q, want the framed message", got)
+	}
+	if d := time.Since(start); d < 900*time.Millisecond {
+		t.Errorf("keep-alive after %s, want after the 1s interval", d)
+	}
+}
+
+func TestTrafficPostponesIdleKeepAlive(t *testing.T) {
+	tenant := newIdleTestTenant("")
+	tenant.KeepAliveMessage = "PING"
+	client, c := startIdleKeepAlive(t, tenant)
+	go runConnectionWriter(tenant, c)
+	go io.Copy(io.Discard, client)
+
+	// traffic every 300ms keeps the connection from ever being idle for the 1s interval
+	for i := 0; i < 6; i++ {
+		time.Sleep(300 * time.Millisecond)
+		touchConnection(c)
+	}
+	if n := atomic.LoadUint64(&tenant.KeepAliveSeq); n != 0 {
+		t.Fatalf("%d keep-alive(s) sent to a busy connection, want 0", n)
+	}
+	if next := time.Unix(0, atomic.LoadInt64(&c.NextKeepAlive)); next.Before(lastActivity(c)) {
+		t.Errorf("next keep-alive at %s, before the last traffic", next.Format(time.RFC3339Nano))
+	}
+
+	// once the traffic stops, the probe follows an interval later
+	time.Sleep(1500 * time.Millisecond)
+	if n := atomic.LoadUint64(&tenant.KeepAliveSeq); n != 1 {
+		t.Errorf("%d keep-alive(s) 1.5s after the last traffic, want 1", n)
+	}
+}
@@ -0,0 +1,215 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// -----------------------------------------------------------
+// Keep-Alive JSON Documents (key order, numbers and indentation kept)
+// -----------------------------------------------------------
+
+// jsonObject is a JSON object that remembers the order of its keys. Values are json.Number,
+// string, bool, nil, []interface{} or *jsonObject.
+type jsonObject struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+func newJSONObject() *jsonObject {
+	return &jsonObject{values: make(map[string]interface{})}
+}
+
+func (o *jsonObject) put(key string, v interface{}) {
+	if _, ok := o.values[key]; !ok {
+		o.keys = append(o.keys, key)
+	}
+	o.values[key] = v
+}
+
+// jsonDocument is a keep-alive JSON object. Numbers keep their literal (no float64 rounding of
+// large integers) and the document is written with the indentation of the original, so updating
+// a few fields leaves the rest of the file as the operator wrote it.
+type jsonDocument struct {
+	root            *jsonObject
+	indent          string // one level of indentation, empty for compact documents
+	trailingNewline bool
+}
+
+func parseJSONDocument(data []byte) (*jsonDocument, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	v, err := readJSONValue(dec)
+	if err != nil {
+		return nil, err
+	}
+	root, ok := v.(*jsonObject)
+	if !ok {
+		return nil, errors.New("not a JSON object")
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, errors.New("unexpected data after the JSON object")
+	}
+	return &jsonDocument{
+		root:            root,
+		indent:          jsonIndent(data),
+		trailingNewline: bytes.HasSuffix(data, []byte("\n")),
+	}, nil
+}
+
+func readJSONValue(dec *json.Decoder) (interface{}, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch tok {
+	case json.Delim('{'):
+		obj := newJSONObject()
+		for dec.More() {
+			keyTok, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			v, err := readJSONValue(dec)
+			if err != nil {
+				return nil, err
+			}
+			obj.put(keyTok.(string), v)
+		}
+		_, err := dec.Token() // '}'
+		return obj, err
+	case json.Delim('['):
+		arr := []interface{}{}
+		for dec.More() {
+			v, err := readJSONValue(dec)
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, v)
+		}
+		_, err := dec.Token() // ']'
+		return arr, err
+	default:
+		return tok, nil
+	}
+}
+
+// jsonIndent returns the whitespace in front of the first nested line, "" if data is on one line.
+func jsonIndent(data []byte) string {
+	data = bytes.TrimRight(data, " \t\r\n")
+	i := bytes.IndexByte(data, '\n')
+	if i < 0 {
+		return ""
+	}
+	rest := data[i+1:]
+	n := 0
+	for n < len(rest) && (rest[n] == ' ' || rest[n] == '\t') {
+		n++
+	}
+	if n == 0 {
+		return "  "
+	}
+	return string(rest[:n])
+}
+
+// set sets a string value at a "/" separated path of nested objects, creating missing ones.
+func (d *jsonDocument) set(path, value string) error {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	obj := d.root
+	for _, seg := range segments[:len(segments)-1] {
+		next, ok := obj.values[seg]
+		if !ok {
+			child := newJSONObject()
+			obj.put(seg, child)
+			obj = child
+			continue
+		}
+		child, ok := next.(*jsonObject)
+		if !ok {
+			return fmt.Errorf("keep-alive field path %q: %q is not an object", path, seg)
+		}
+		obj = child
+	}
+	obj.put(segments[len(segments)-1], value)
+	return nil
+}
+
+func (d *jsonDocument) bytes() []byte {
+	var buf bytes.Buffer
+	writeJSONValue(&buf, d.root, d.indent, 0)
+	if d.trailingNewline {
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes()
+}
+
+func writeJSONValue(buf *bytes.Buffer, v interface{}, indent string, depth int) {
+	newline := func(depth int) {
+		if indent != "" {
+			buf.WriteByte('\n')
+			buf.WriteString(strings.Repeat(indent, depth))
+		}
+	}
+	switch v := v.(type) {
+	case *jsonObject:
+		if len(v.keys) == 0 {
+			buf.WriteString("{}")
+			return
+		}
+		buf.WriteByte('{')
+		for i, key := range v.keys {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			newline(depth + 1)
+			writeJSONString(buf, key)
+			buf.WriteByte(':')
+			if indent != "" {
+				buf.WriteByte(' ')
+			}
+			writeJSONValue(buf, v.values[key], indent, depth+1)
+		}
+		newline(depth)
+		buf.WriteByte('}')
+	case []interface{}:
+		if len(v) == 0 {
+			buf.WriteString("[]")
+			return
+		}
+		buf.WriteByte('[')
+		for i, elem := range v {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			newline(depth + 1)
+			writeJSONValue(buf, elem, indent, depth+1)
+		}
+		newline(depth)
+		buf.WriteByte(']')
+	case json.Number:
+		buf.WriteString(v.String())
+	case string:
+		writeJSONString(buf, v)
+	case bool:
+		if v {
+			buf.WriteString("true")
+		} else {
+			buf.WriteString("false")
+		}
+	case nil:
+		buf.WriteString("null")
+	}
+}
+
+// writeJSONString quotes s without escaping <, > and &, which are common in keep-alives.
+func writeJSONString(buf *bytes.Buffer, s string) {
+	var tmp bytes.Buffer
+	enc := json.NewEncoder(&tmp)
+	enc.SetEscapeHTML(false)
+	_ = enc.Encode(s)
+	buf.Write(bytes.TrimSuffix(tmp.Bytes(), []byte("\n")))
+}
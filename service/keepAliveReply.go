@@ -0,0 +1,89 @@
+package service
+
+import (
+	"log"
+	"regexp"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"tcp_sandbox/domain"
+)
+
+// -----------------------------------------------------------
+// Keep-Alive Replies & Dead-Peer Detection
+// -----------------------------------------------------------
+
+const (
+	defaultKeepAliveReplyTimeout = 10 * time.Second
+	defaultKeepAliveMaxMissed    = 3
+)
+
+var (
+	framePatterns     = make(map[string]*regexp.Regexp)
+	framePatternsLock sync.Mutex
+)
+
+// compileFramePattern compiles a configured frame pattern once and caches it.
+func compileFramePattern(expr string) (*regexp.Regexp, error) {
+	framePatternsLock.Lock()
+	defer framePatternsLock.Unlock()
+
+	if re, ok := framePatterns[expr]; ok {
+		return re, nil
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	framePatterns[expr] = re
+	return re, nil
+}
+
+// expectKeepAliveReply checks, after the reply timeout, that the client answered the keep-alive
+// sent at sentAt. Too many consecutive missed replies close the connection.
+func expectKeepAliveReply(t *domain.Tenant, c *domain.Connection, cfg keepAliveConfig, sentAt time.Time) {
+	if cfg.ReplyPattern == "" {
+		return
+	}
+	time.AfterFunc(cfg.ReplyTimeout, func() {
+		select {
+		case <-c.Closed:
+			return
+		default:
+		}
+		// A client heartbeat proves the peer alive just as well as a reply
+		if atomic.LoadInt64(&c.LastKeepAliveReply) >= sentAt.UnixNano() || atomic.LoadInt64(&c.LastHeartbeat) >= sentAt.UnixNano() {
+			return
+		}
+
+		missed := atomic.AddUint64(&c.MissedKeepAlives, 1)
+		atomic.AddUint64(&c.MissedKeepAlivesTotal, 1)
+		log.Printf("[WARN][Tenant %q] No keep-alive reply from %s within %s (%d/%d missed)",
+			cfg.TenantName, c.Conn.RemoteAddr(), cfg.ReplyTimeout, missed, cfg.MaxMissed)
+		if missed >= uint64(cfg.MaxMissed) {
+			log.Printf("[WARN][Tenant %q] Closing dead connection %s", cfg.TenantName, c.Conn.RemoteAddr())
+			c.Conn.Close()
+		}
+	})
+}
+
+// handleKeepAliveReply reports whether msg answers a keep-alive. Replies mark the connection
+// alive and are consumed: they are neither echoed nor forwarded upstream.
+func handleKeepAliveReply(t *domain.Tenant, c *domain.Connection, msg string) bool {
+	if t.KeepAliveReplyPattern == "" {
+		return false
+	}
+	re, err := compileFramePattern(t.KeepAliveReplyPattern)
+	if err != nil {
+		logError(t, err)
+		return false
+	}
+	if !re.MatchString(msg) {
+		return false
+	}
+
+	atomic.StoreInt64(&c.LastKeepAliveReply, time.Now().UnixNano())
+	atomic.StoreUint64(&c.MissedKeepAlives, 0)
+	return true
+}
@@ -0,0 +1,47 @@
+package service
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"tcp_sandbox/domain"
+)
+
+func TestKeepAliveReplyTimeoutClosesConnection(t *testing.T) {
+	tenant := &domain.Tenant{TenantConfig: domain.TenantConfig{
+		ID: "reply", Name: "reply", StartByte: 2, EndByte: 3, KeepAliveReplyPattern: "^PONG",
+	}}
+	client := connectTestClient(t, tenant)
+	tenant.ConnectionsLock.Lock()
+	c := tenant.Connections[0]
+	tenant.ConnectionsLock.Unlock()
+	cfg := keepAliveConfig{TenantName: "reply", ReplyPattern: "^PONG", ReplyTimeout: 100 * time.Millisecond, MaxMissed: 2}
+
+	// an answered keep-alive: the reply is consumed, neither echoed nor forwarded
+	expectKeepAliveReply(tenant, c, cfg, time.Now())
+	if _, err := client.Write([]byte("\x02PONG 1\x03")); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if missed := atomic.LoadUint64(&c.MissedKeepAlivesTotal); missed != 0 {
+		t.Errorf("%d missed replies after an answer, want 0", missed)
+	}
+	if reply, messages := atomic.LoadInt64(&c.LastKeepAliveReply), atomic.LoadUint64(&tenant.Messages); reply == 0 || messages != 0 {
+		t.Errorf("reply not consumed: LastKeepAliveReply %d, Messages %d", reply, messages)
+	}
+
+	// MaxMissed unanswered keep-alives close the connection
+	expectKeepAliveReply(tenant, c, cfg, time.Now())
+	time.Sleep(200 * time.Millisecond)
+	if missed := atomic.LoadUint64(&c.MissedKeepAlives); missed != 1 {
+		t.Fatalf("%d missed replies, want 1", missed)
+	}
+	expectKeepAliveReply(tenant, c, cfg, time.Now())
+	if got := readUntilClosed(t, client); got != "" {
+		t.Errorf("client got %q, want nothing", got)
+	}
+	if missed := atomic.LoadUint64(&c.MissedKeepAlivesTotal); missed != 2 {
+		t.Errorf("MissedKeepAlivesTotal = %d, want 2", missed)
+	}
+}
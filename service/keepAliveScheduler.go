@@ -0,0 +1,193 @@
+package service
+
+import (
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"tcp_sandbox/domain"
+	"tcp_sandbox/globals"
+)
+
+// -----------------------------------------------------------
+// Keep-Alive Scheduler (one per tenant, tied to its lifecycle)
+// -----------------------------------------------------------
+
+// keepAliveScheduler sends a tenant's keep-alives until it is stopped.
+// A config change replaces the scheduler rather than mutating it.
+// In idle mode it has no ticker of its own; every connection runs runIdleKeepAlive instead.
+type keepAliveScheduler struct {
+	tenant   *domain.Tenant
+	interval time.Duration
+	file     string
+	idle     bool
+	jitter   time.Duration
+	stop     chan struct{}
+
+	mu       sync.Mutex
+	nextFire time.Time
+}
+
+var (
+	keepAliveSchedulers     = make(map[*domain.Tenant]*keepAliveScheduler)
+	keepAliveChanges        = make(map[*domain.Tenant]chan struct{}) // closed and replaced on every scheduler change
+	keepAliveSchedulersLock sync.Mutex
+)
+
+// keepAliveWanted reports whether the tenant has a keep-alive configured.
+func keepAliveWanted(t *domain.Tenant) bool {
+	return t.KeepAliveIntervalSec > 0 && (t.KeepAliveFile != "" || t.KeepAliveMessage != "")
+}
+
+// isIdleKeepAlive reports whether keep-alives are scheduled per connection.
+func isIdleKeepAlive(t *domain.Tenant) bool {
+	return strings.EqualFold(t.KeepAliveMode, "idle")
+}
+
+// syncKeepAlive starts, reschedules or stops the tenant's keep-alive to match its config.
+// Caller holds globals.TenantsLock.
+func syncKeepAlive(t *domain.Tenant) {
+	keepAliveSchedulersLock.Lock()
+	defer keepAliveSchedulersLock.Unlock()
+
+	wanted := keepAliveWanted(t)
+	interval := time.Duration(t.KeepAliveIntervalSec) * time.Second
+	idle := isIdleKeepAlive(t)
+	jitter := time.Duration(t.KeepAliveJitterSec) * time.Second
+
+	s, running := keepAliveSchedulers[t]
+	if running && wanted && s.interval == interval && s.file == t.KeepAliveFile && s.idle == idle && s.jitter == jitter {
+		return
+	}
+	defer notifyKeepAliveChange(t)
+	if running {
+		close(s.stop)
+		delete(keepAliveSchedulers, t)
+		if !wanted {
+			log.Printf("[Tenant %q] Keep-alive stopped", t.Name)
+			return
+		}
+	}
+	if !wanted {
+		return
+	}
+
+	s = &keepAliveScheduler{
+		tenant:   t,
+		interval: interval,
+		file:     t.KeepAliveFile,
+		idle:     idle,
+		jitter:   jitter,
+		stop:     make(chan struct{}),
+	}
+	keepAliveSchedulers[t] = s
+	if idle {
+		log.Printf("[Tenant %q] Keep-alive scheduled per connection after %s idle (jitter %s)", t.Name, interval, jitter)
+		return
+	}
+	go s.run()
+	log.Printf("[Tenant %q] Keep-alive scheduled every %s", t.Name, interval)
+}
+
+// keepAliveChanged returns a channel that is closed on the tenant's next keep-alive config change.
+func keepAliveChanged(t *domain.Tenant) <-chan struct{} {
+	keepAliveSchedulersLock.Lock()
+	defer keepAliveSchedulersLock.Unlock()
+
+	ch, ok := keepAliveChanges[t]
+	if !ok {
+		ch = make(chan struct{})
+		keepAliveChanges[t] = ch
+	}
+	return ch
+}
+
+// notifyKeepAliveChange wakes everyone waiting on keepAliveChanged. Caller holds keepAliveSchedulersLock.
+func notifyKeepAliveChange(t *domain.Tenant) {
+	if ch, ok := keepAliveChanges[t]; ok {
+		close(ch)
+		delete(keepAliveChanges, t)
+	}
+}
+
+// stopRemovedKeepAlives stops schedulers of tenants no longer in globals.Tenants.
+// Caller holds globals.TenantsLock.
+func stopRemovedKeepAlives() {
+	active := make(map[*domain.Tenant]bool, len(globals.Tenants))
+	for _, t := range globals.Tenants {
+		active[t] = true
+	}
+
+	keepAliveSchedulersLock.Lock()
+	defer keepAliveSchedulersLock.Unlock()
+
+	for t, s := range keepAliveSchedulers {
+		if !active[t] {
+			close(s.stop)
+			delete(keepAliveSchedulers, t)
+			log.Printf("[Tenant %q] Keep-alive stopped (tenant removed)", t.Name)
+		}
+	}
+	for t := range keepAliveChanges {
+		if !active[t] {
+			notifyKeepAliveChange(t)
+		}
+	}
+}
+
+// keepAliveNextFire returns when the tenant's next keep-alive is due, or the zero time if none is scheduled.
+func keepAliveNextFire(t *domain.Tenant) time.Time {
+	keepAliveSchedulersLock.Lock()
+	s, ok := keepAliveSchedulers[t]
+	keepAliveSchedulersLock.Unlock()
+	if !ok {
+		return time.Time{}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.nextFire
+}
+
+func (s *keepAliveScheduler) run() {
+	timer := time.NewTimer(s.interval)
+	defer timer.Stop()
+	s.setNextFire(time.Now().Add(s.interval))
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-timer.C:
+			if !s.fire() {
+				return
+			}
+			timer.Reset(s.interval)
+			s.setNextFire(time.Now().Add(s.interval))
+		}
+	}
+}
+
+// fire sends one keep-alive unless the scheduler was stopped while waiting for the lock.
+// The global lock is only held to snapshot the tenant's config, not while rendering or sending.
+func (s *keepAliveScheduler) fire() bool {
+	globals.TenantsLock.Lock()
+	select {
+	case <-s.stop:
+		globals.TenantsLock.Unlock()
+		return false
+	default:
+	}
+	cfg := keepAliveConfigOf(s.tenant)
+	globals.TenantsLock.Unlock()
+
+	sendTenantKeepAlive(s.tenant, cfg)
+	return true
+}
+
+func (s *keepAliveScheduler) setNextFire(at time.Time) {
+	s.mu.Lock()
+	s.nextFire = at
+	s.mu.Unlock()
+}
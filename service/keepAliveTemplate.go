@@ -0,0 +1,276 @@
+package service
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// -----------------------------------------------------------
+// Keep-Alive Templates (loaded once, reloaded when the file changes)
+// -----------------------------------------------------------
+
+// processStart is used for the {{.Uptime}} template variable.
+var processStart = time.Now()
+
+// keepAliveVars are available to keep-alive templates, e.g. <sendTime>{{.SendTime}}</sendTime>.
+type keepAliveVars struct {
+	TenantName      string
+	SendTime        string // RFC 3339, UTC
+	Sequence        uint64 // per tenant, starts at 1
+	ConnectionCount int
+	Uptime          string // process uptime, e.g. "3h2m1s"
+	UptimeSec       int64
+}
+
+var keepAliveTemplateFuncs = template.FuncMap{
+	// xml escapes a value for use in XML text or attributes: {{xml .TenantName}}
+	"xml": func(s string) (string, error) {
+		var buf bytes.Buffer
+		err := xml.EscapeText(&buf, []byte(s))
+		return buf.String(), err
+	},
+}
+
+// keepAliveTemplate is the in-memory copy of one keep-alive file (or inline KeepAliveMessage).
+// How the content is used depends on the tenant's KeepAliveFormat:
+//
+//   - xml:  content containing "{{" is a text/template; otherwise an XML document of any
+//     structure in which only the configured fields (see xmlDocument.set) are updated
+//   - json: the same, with a JSON object instead of an XML document (see jsonDocument)
+//   - text: a text/template (plain text is sent as is, e.g. "PING")
+//   - hex:  raw bytes written as hex, e.g. "05" for ENQ
+type keepAliveTemplate struct {
+	mu      sync.Mutex
+	path    string // empty for inline messages
+	modTime time.Time
+	size    int64
+	missing bool
+	loaded  bool
+	data    []byte
+
+	// Parsed lazily for the format in use, reset whenever data changes
+	tmpl    *template.Template
+	xmlDoc  *xmlDocument
+	jsonDoc *jsonDocument
+}
+
+var (
+	keepAliveTemplates     = make(map[string]*keepAliveTemplate)
+	keepAliveTemplatesLock sync.Mutex
+)
+
+func getKeepAliveTemplate(path string) *keepAliveTemplate {
+	keepAliveTemplatesLock.Lock()
+	defer keepAliveTemplatesLock.Unlock()
+
+	kt, ok := keepAliveTemplates[path]
+	if !ok {
+		kt = &keepAliveTemplate{path: path}
+		keepAliveTemplates[path] = kt
+	}
+	return kt
+}
+
+// inlineKeepAliveTemplate wraps a KeepAliveMessage configured directly on the tenant.
+func inlineKeepAliveTemplate(message string) *keepAliveTemplate {
+	return &keepAliveTemplate{data: []byte(message), loaded: true}
+}
+
+// render produces the keep-alive payload in the given format. For XML/JSON documents the
+// fields (path -> value template, defaultKeepAliveFields if empty) are updated and, if writeBack
+// is set, the file is written back; templates, text and hex are never written.
+func (kt *keepAliveTemplate) render(format string, vars keepAliveVars, fields map[string]string, writeBack bool) ([]byte, error) {
+	kt.mu.Lock()
+	defer kt.mu.Unlock()
+
+	if kt.path != "" {
+		if err := kt.reloadIfChanged(vars.TenantName); err != nil {
+			return nil, err
+		}
+	}
+
+	switch format {
+	case "hex":
+		if kt.missing {
+			return nil, fmt.Errorf("keep-alive file %s not found", kt.path)
+		}
+		return decodeHexPayload(string(kt.data))
+	case "text":
+		if kt.missing {
+			return nil, fmt.Errorf("keep-alive file %s not found", kt.path)
+		}
+		return kt.execTemplate(vars)
+	case "xml", "json":
+		if bytes.Contains(kt.data, []byte("{{")) {
+			return kt.execTemplate(vars)
+		}
+	default:
+		return nil, fmt.Errorf("unknown keep-alive format %q", format)
+	}
+
+	var out []byte
+	var err error
+	if format == "xml" {
+		out, err = kt.renderXML(vars, fields)
+	} else {
+		out, err = kt.renderJSON(vars, fields)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if writeBack && kt.path != "" && !kt.missing {
+		if err := writeFileAtomic(kt.path, out, 0644); err != nil {
+			log.Printf("[ERROR][Tenant %q] Could not write keep-alive file: %v", vars.TenantName, err)
+		} else if fi, err := os.Stat(kt.path); err == nil {
+			// Our own write is not a change that needs reloading
+			kt.modTime, kt.size = fi.ModTime(), fi.Size()
+		}
+	}
+	return out, nil
+}
+
+func (kt *keepAliveTemplate) execTemplate(vars keepAliveVars) ([]byte, error) {
+	if kt.tmpl == nil {
+		tmpl, err := template.New("keepalive").Funcs(keepAliveTemplateFuncs).Parse(string(kt.data))
+		if err != nil {
+			return nil, fmt.Errorf("parsing keep-alive template %s: %w", kt.path, err)
+		}
+		kt.tmpl = tmpl
+	}
+	var buf bytes.Buffer
+	if err := kt.tmpl.Execute(&buf, vars); err != nil {
+		return nil, fmt.Errorf("executing keep-alive template %s: %w", kt.path, err)
+	}
+	return buf.Bytes(), nil
+}
+
+func (kt *keepAliveTemplate) renderXML(vars keepAliveVars, fields map[string]string) ([]byte, error) {
+	if kt.xmlDoc == nil {
+		if kt.missing {
+			kt.xmlDoc = defaultKeepAliveDocument()
+		} else {
+			doc, err := parseXMLDocument(kt.data)
+			if err != nil {
+				return nil, fmt.Errorf("parsing keep-alive XML %s: %w", kt.path, err)
+			}
+			kt.xmlDoc = doc
+		}
+	}
+	if err := applyKeepAliveFields(fields, vars, kt.xmlDoc.set); err != nil {
+		return nil, err
+	}
+	return kt.xmlDoc.bytes(), nil
+}
+
+func (kt *keepAliveTemplate) renderJSON(vars keepAliveVars, fields map[string]string) ([]byte, error) {
+	if kt.jsonDoc == nil {
+		if kt.missing {
+			kt.jsonDoc = &jsonDocument{root: newJSONObject()}
+		} else {
+			doc, err := parseJSONDocument(kt.data)
+			if err != nil {
+				return nil, fmt.Errorf("parsing keep-alive JSON %s: %w", kt.path, err)
+			}
+			kt.jsonDoc = doc
+		}
+	}
+	if err := applyKeepAliveFields(fields, vars, kt.jsonDoc.set); err != nil {
+		return nil, err
+	}
+	return kt.jsonDoc.bytes(), nil
+}
+
+// reloadIfChanged re-reads the file only if its modification time or size changed.
+func (kt *keepAliveTemplate) reloadIfChanged(tenantName string) error {
+	fi, err := os.Stat(kt.path)
+	if err != nil {
+		if !kt.missing {
+			log.Printf("[WARN][Tenant %q] Could not load keep-alive file (%s): %v. Using default.", tenantName, kt.path, err)
+			kt.setData(nil)
+		}
+		kt.missing = true
+		return nil
+	}
+	if kt.loaded && !kt.missing && fi.ModTime().Equal(kt.modTime) && fi.Size() == kt.size {
+		return nil
+	}
+
+	data, err := os.ReadFile(kt.path)
+	if err != nil {
+		return err
+	}
+	kt.missing = false
+	kt.modTime, kt.size = fi.ModTime(), fi.Size()
+	kt.setData(data)
+	log.Printf("[Tenant %q] Loaded keep-alive file %s", tenantName, kt.path)
+	return nil
+}
+
+func (kt *keepAliveTemplate) setData(data []byte) {
+	kt.data = data
+	kt.loaded = true
+	kt.tmpl, kt.xmlDoc, kt.jsonDoc = nil, nil, nil
+}
+
+// applyKeepAliveFields renders each field value and hands it to set, in path order
+// so missing elements are always created in the same order.
+func applyKeepAliveFields(fields map[string]string, vars keepAliveVars, set func(path, value string) error) error {
+	if len(fields) == 0 {
+		fields = defaultKeepAliveFields
+	}
+	paths := make([]string, 0, len(fields))
+	for path := range fields {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		value, err := renderFieldValue(fields[path], vars)
+		if err != nil {
+			return fmt.Errorf("keep-alive field %q: %w", path, err)
+		}
+		if err := set(path, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// decodeHexPayload parses raw bytes written as hex, e.g. "05", "0x05" or "02 50 49 4E 47 03".
+func decodeHexPayload(s string) ([]byte, error) {
+	var digits strings.Builder
+	for _, f := range strings.Fields(strings.ReplaceAll(s, ",", " ")) {
+		f = strings.TrimPrefix(strings.TrimPrefix(f, "0x"), "0X")
+		digits.WriteString(f)
+	}
+	out, err := hex.DecodeString(digits.String())
+	if err != nil {
+		return nil, fmt.Errorf("invalid hex keep-alive: %w", err)
+	}
+	return out, nil
+}
+
+// renderFieldValue expands a keep-alive field value, which may use the template variables.
+func renderFieldValue(valueTmpl string, vars keepAliveVars) (string, error) {
+	if !strings.Contains(valueTmpl, "{{") {
+		return valueTmpl, nil
+	}
+	tmpl, err := template.New("field").Funcs(keepAliveTemplateFuncs).Parse(valueTmpl)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, vars); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
@@ -0,0 +1,123 @@
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testKeepAliveVars() keepAliveVars {
+	return keepAliveVars{TenantName: "A", SendTime: "2026-10-18T12:00:00Z", Sequence: 3}
+}
+
+// writeKeepAliveFile writes a keep-alive file and returns a template for it.
+func writeKeepAliveFile(t *testing.T, name, content string) (*keepAliveTemplate, string) {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return &keepAliveTemplate{path: path}, path
+}
+
+func TestKeepAliveFileWrittenBackOnlyOnRequest(t *testing.T) {
+	const content = "<keepAlive>\n  <tenantName/>\n  <sendTime/>\n</keepAlive>"
+
+	kt, path := writeKeepAliveFile(t, "ka.xml", content)
+	out, err := kt.render("xml", testKeepAliveVars(), nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(out), "<sendTime>2026-10-18T12:00:00Z</sendTime>") {
+		t.Errorf("rendered keep-alive lacks the send time:\n%s", out)
+	}
+	if data, _ := os.ReadFile(path); string(data) != content {
+		t.Errorf("file changed without KeepAliveWriteBack:\n%s", data)
+	}
+
+	kt, path = writeKeepAliveFile(t, "ka.xml", content)
+	out, err = kt.render("xml", testKeepAliveVars(), nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != string(out) {
+		t.Errorf("file not written back with KeepAliveWriteBack:\n%s", data)
+	}
+}
+
+func TestJSONDocumentKeepsNumbersOrderAndIndentation(t *testing.T) {
+	for _, tc := range []struct {
+		name, in, path, value, want string
+	}{
+		{
+			name:  "indented, large integer",
+			in:    "{\n    \"id\": 9007199254740993,\n    \"ratio\": 1.50,\n    \"device\": {\"on\": true, \"tags\": [\"a\", null]},\n    \"sendTime\": \"\"\n}\n",
+			path:  "sendTime",
+			value: "2026-10-18T12:00:00Z",
+			want:  "{\n    \"id\": 9007199254740993,\n    \"ratio\": 1.50,\n    \"device\": {\n        \"on\": true,\n        \"tags\": [\n            \"a\",\n            null\n        ]\n    },\n    \"sendTime\": \"2026-10-18T12:00:00Z\"\n}\n",
+		},
+		{
+			name:  "compact, nested path created at the end",
+			in:    `{"z":1,"a":{"b":2}}`,
+			path:  "meta/seq",
+			value: "3",
+			want:  `{"z":1,"a":{"b":2},"meta":{"seq":"3"}}`,
+		},
+		{
+			name:  "tab indentation, no html escaping",
+			in:    "{\n\t\"msg\": \"x\"\n}",
+			path:  "msg",
+			value: "<ping & pong>",
+			want:  "{\n\t\"msg\": \"<ping & pong>\"\n}",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			doc, err := parseJSONDocument([]byte(tc.in))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := doc.set(tc.path, tc.value); err != nil {
+				t.Fatal(err)
+			}
+			if got := string(doc.bytes()); got != tc.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestJSONDocumentErrors(t *testing.T) {
+	for _, in := range []string{`[1, 2]`, `{"a": 1} {"b": 2}`, `{"a": `} {
+		if _, err := parseJSONDocument([]byte(in)); err == nil {
+			t.Errorf("parseJSONDocument(%q) succeeded", in)
+		}
+	}
+
+	doc, err := parseJSONDocument([]byte(`{"a": "text"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := doc.set("a/b", "x"); err == nil {
+		t.Errorf("set through a string value succeeded")
+	}
+}
+
+func TestJSONKeepAliveWriteBackKeepsFormatting(t *testing.T) {
+	const content = "{\n  \"counter\": 12345678901234567890,\n  \"tenantName\": \"\",\n  \"sendTime\": \"\"\n}\n"
+	kt, path := writeKeepAliveFile(t, "ka.json", content)
+
+	out, err := kt.render("json", testKeepAliveVars(), nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "{\n  \"counter\": 12345678901234567890,\n  \"tenantName\": \"A\",\n  \"sendTime\": \"2026-10-18T12:00:00Z\"\n}\n"
+	if string(out) != want {
+		t.Errorf("rendered:\n%s\nwant:\n%s", out, want)
+	}
+	if data, _ := os.ReadFile(path); string(data) != want {
+		t.Errorf("written back:\n%s\nwant:\n%s", data, want)
+	}
+	if matches, _ := filepath.Glob(filepath.Join(filepath.Dir(path), ".ka.json.tmp-*")); len(matches) > 0 {
+		t.Errorf("temporary files left behind: %v", matches)
+	}
+}
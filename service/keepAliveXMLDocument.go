@@ -0,0 +1,214 @@
+package service
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// -----------------------------------------------------------
+// Keep-Alive XML Documents (arbitrary structure, round-trip safe)
+// -----------------------------------------------------------
+
+// defaultKeepAliveFields are updated when a tenant configures no KeepAliveFields,
+// matching the historic <keepAlive><tenantName/><sendTime/></keepAlive> document.
+var defaultKeepAliveFields = map[string]string{
+	"tenantName": "{{.TenantName}}",
+	"sendTime":   "{{.SendTime}}",
+}
+
+// xmlNode is one element. Names keep their raw prefix in Name.Space, so namespaces,
+// attributes, comments and whitespace survive a parse/serialize round trip unchanged.
+type xmlNode struct {
+	Name     xml.Name
+	Attr     []xml.Attr
+	Children []interface{} // *xmlNode, xml.CharData, xml.Comment, xml.ProcInst or xml.Directive
+}
+
+// xmlDocument is a parsed keep-alive file: the prolog/epilog tokens plus exactly one root element.
+type xmlDocument struct {
+	Nodes []interface{}
+	Root  *xmlNode
+}
+
+// parseXMLDocument reads an XML document without interpreting namespaces.
+func parseXMLDocument(data []byte) (*xmlDocument, error) {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	doc := &xmlDocument{}
+	var stack []*xmlNode
+
+	for {
+		tok, err := dec.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			n := &xmlNode{Name: tok.Name, Attr: append([]xml.Attr(nil), tok.Attr...)}
+			if len(stack) == 0 {
+				if doc.Root != nil {
+					return nil, errors.New("more than one root element")
+				}
+				doc.Root = n
+				doc.Nodes = append(doc.Nodes, n)
+			} else {
+				parent := stack[len(stack)-1]
+				parent.Children = append(parent.Children, n)
+			}
+			stack = append(stack, n)
+		case xml.EndElement:
+			if len(stack) == 0 {
+				return nil, fmt.Errorf("unexpected </%s>", tok.Name.Local)
+			}
+			stack = stack[:len(stack)-1]
+		default:
+			tok = xml.CopyToken(tok)
+			if len(stack) == 0 {
+				doc.Nodes = append(doc.Nodes, tok)
+			} else {
+				parent := stack[len(stack)-1]
+				parent.Children = append(parent.Children, tok)
+			}
+		}
+	}
+	if len(stack) != 0 {
+		return nil, fmt.Errorf("unclosed element <%s>", stack[len(stack)-1].Name.Local)
+	}
+	if doc.Root == nil {
+		return nil, errors.New("no root element")
+	}
+	return doc, nil
+}
+
+// defaultKeepAliveDocument is used when a tenant's keep-alive file does not exist.
+func defaultKeepAliveDocument() *xmlDocument {
+	doc, _ := parseXMLDocument([]byte("<keepAlive>\n  <tenantName/>\n  <sendTime/>\n</keepAlive>"))
+	return doc
+}
+
+// set updates the element or attribute at path, relative to the root element, creating
+// missing elements. Segments match local names; a final "@name" segment addresses an attribute.
+//
+//	"sendTime"            -> <root><sendTime>value</sendTime></root>
+//	"header/device/@seq"  -> <root><header><device seq="value"/></header></root>
+func (d *xmlDocument) set(path, value string) error {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	if len(segments) == 0 || segments[0] == "" {
+		return fmt.Errorf("empty keep-alive field path")
+	}
+
+	n := d.Root
+	for i, seg := range segments {
+		if strings.HasPrefix(seg, "@") {
+			if i != len(segments)-1 {
+				return fmt.Errorf("keep-alive field path %q: attribute must be the last segment", path)
+			}
+			n.setAttr(seg[1:], value)
+			return nil
+		}
+		n = n.child(seg)
+	}
+
+	// Leaf element: its content becomes the value
+	n.Children = []interface{}{xml.CharData(value)}
+	return nil
+}
+
+// child returns the first child element with the given local name, appending one if none exists.
+func (n *xmlNode) child(name string) *xmlNode {
+	prefix, local := splitQName(name)
+	for _, c := range n.Children {
+		if cn, ok := c.(*xmlNode); ok && cn.Name.Local == local && (prefix == "" || cn.Name.Space == prefix) {
+			return cn
+		}
+	}
+	cn := &xmlNode{Name: xml.Name{Space: prefix, Local: local}}
+	n.Children = append(n.Children, cn)
+	return cn
+}
+
+func (n *xmlNode) setAttr(name, value string) {
+	prefix, local := splitQName(name)
+	for i := range n.Attr {
+		if n.Attr[i].Name.Local == local && (prefix == "" || n.Attr[i].Name.Space == prefix) {
+			n.Attr[i].Value = value
+			return
+		}
+	}
+	n.Attr = append(n.Attr, xml.Attr{Name: xml.Name{Space: prefix, Local: local}, Value: value})
+}
+
+func splitQName(name string) (prefix, local string) {
+	if i := strings.Index(name, ":"); i >= 0 {
+		return name[:i], name[i+1:]
+	}
+	return "", name
+}
+
+// bytes serializes the document exactly as parsed, apart from the updated fields.
+func (d *xmlDocument) bytes() []byte {
+	var buf bytes.Buffer
+	for _, n := range d.Nodes {
+		writeXMLItem(&buf, n)
+	}
+	return buf.Bytes()
+}
+
+func writeXMLItem(buf *bytes.Buffer, item interface{}) {
+	switch it := item.(type) {
+	case *xmlNode:
+		name := qName(it.Name)
+		buf.WriteString("<" + name)
+		for _, a := range it.Attr {
+			buf.WriteString(" " + qName(a.Name) + `="`)
+			buf.WriteString(xmlAttrEscaper.Replace(a.Value))
+			buf.WriteString(`"`)
+		}
+		if len(it.Children) == 0 {
+			buf.WriteString("/>")
+			return
+		}
+		buf.WriteString(">")
+		for _, c := range it.Children {
+			writeXMLItem(buf, c)
+		}
+		buf.WriteString("</" + name + ">")
+	case xml.CharData:
+		buf.WriteString(xmlTextEscaper.Replace(string(it)))
+	case xml.Comment:
+		buf.WriteString("<!--")
+		buf.Write(it)
+		buf.WriteString("-->")
+	case xml.ProcInst:
+		buf.WriteString("<?" + it.Target)
+		if len(it.Inst) > 0 {
+			buf.WriteString(" ")
+			buf.Write(it.Inst)
+		}
+		buf.WriteString("?>")
+	case xml.Directive:
+		buf.WriteString("<!")
+		buf.Write(it)
+		buf.WriteString(">")
+	}
+}
+
+// Unlike xml.EscapeText these leave whitespace alone, so indentation is preserved.
+var (
+	xmlTextEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+	xmlAttrEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;", "\n", "&#xA;", "\t", "&#x9;")
+)
+
+func qName(n xml.Name) string {
+	if n.Space != "" {
+		return n.Space + ":" + n.Local
+	}
+	return n.Local
+}
@@ -0,0 +1,75 @@
+package service
+
+import "testing"
+
+func TestXMLDocumentRoundTrip(t *testing.T) {
+	const in = `<?xml version="1.0" encoding="UTF-8"?>
+<!-- keep-alive for the gateway -->
+<gw:keepAlive xmlns:gw="urn:example:gw" version="2">
+    <gw:header id="7" note="a &amp; b">
+        <device>pump &lt;1&gt;</device>
+    </gw:header>
+    <!-- filled in per message -->
+    <sendTime/>
+</gw:keepAlive>
+`
+	doc, err := parseXMLDocument([]byte(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(doc.bytes()); got != in {
+		t.Errorf("round trip changed the document:\n%s\nwant:\n%s", got, in)
+	}
+}
+
+func TestXMLDocumentSet(t *testing.T) {
+	const in = "<keepAlive seq=\"1\">\n  <sendTime/>\n  <gw:device xmlns:gw=\"urn:gw\"><id>4</id></gw:device>\n</keepAlive>"
+	for _, tc := range []struct {
+		path, value, want string
+	}{
+		{"sendTime", "2026-10-18T12:00:00Z",
+			"<keepAlive seq=\"1\">\n  <sendTime>2026-10-18T12:00:00Z</sendTime>\n  <gw:device xmlns:gw=\"urn:gw\"><id>4</id></gw:device>\n</keepAlive>"},
+		{"/@seq", "2",
+			"<keepAlive seq=\"2\">\n  <sendTime/>\n  <gw:device xmlns:gw=\"urn:gw\"><id>4</id></gw:device>\n</keepAlive>"},
+		{"gw:device/id", "<5>",
+			"<keepAlive seq=\"1\">\n  <sendTime/>\n  <gw:device xmlns:gw=\"urn:gw\"><id>&lt;5&gt;</id></gw:device>\n</keepAlive>"},
+		{"device/@state", `"on"`,
+			"<keepAlive seq=\"1\">\n  <sendTime/>\n  <gw:device xmlns:gw=\"urn:gw\" state=\"&quot;on&quot;\"><id>4</id></gw:device>\n</keepAlive>"},
+		{"header/tenant/@name", "A",
+			"<keepAlive seq=\"1\">\n  <sendTime/>\n  <gw:device xmlns:gw=\"urn:gw\"><id>4</id></gw:device>\n<header><tenant name=\"A\"/></header></keepAlive>"},
+	} {
+		doc, err := parseXMLDocument([]byte(in))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := doc.set(tc.path, tc.value); err != nil {
+			t.Errorf("set(%q): %v", tc.path, err)
+			continue
+		}
+		if got := string(doc.bytes()); got != tc.want {
+			t.Errorf("set(%q):\n%s\nwant:\n%s", tc.path, got, tc.want)
+		}
+	}
+}
+
+func TestXMLDocumentErrors(t *testing.T) {
+	for _, in := range []string{
+		"",
+		"<!-- only a comment -->",
+		"<a/><b/>",
+		"<a><b></a>",
+		"<a>",
+		"</a>",
+	} {
+		if _, err := parseXMLDocument([]byte(in)); err == nil {
+			t.Errorf("parseXMLDocument(%q) succeeded", in)
+		}
+	}
+
+	doc := defaultKeepAliveDocument()
+	for _, path := range []string{"", "/", "sendTime/@at/x"} {
+		if err := doc.set(path, "v"); err == nil {
+			t.Errorf("set(%q) succeeded", path)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"tcp_sandbox/globals"
+)
+
+// -----------------------------------------------------------
+// Listener Handoff (restart without closing listening sockets)
+// -----------------------------------------------------------
+
+// ListenerFDsEnv passes listening sockets to a restarted process, as "fd=address" pairs
+// separated by commas, e.g. "4=:3000,5=127.0.0.1:8080".
+const ListenerFDsEnv = "TCP_SANDBOX_LISTENER_FDS"
+
+var (
+	inheritedListeners = make(map[string]net.Listener)
+	inheritedLock      sync.Mutex
+)
+
+// acceptGate holds back accepting after a restart until the previous process knows we are
+// serving (see ReleaseAccepting): until then it keeps accepting on the same sockets and can still
+// stop us without losing a client. Nil if we were not restarted; set before any listener starts.
+var acceptGate chan struct{}
+
+// InheritListeners picks up the listening sockets passed by the previous process, if any.
+// They are used instead of binding when a listener on the same address is started.
+func InheritListeners() error {
+	value := os.Getenv(ListenerFDsEnv)
+	if value == "" {
+		return nil
+	}
+	os.Unsetenv(ListenerFDsEnv)
+	acceptGate = make(chan struct{})
+
+	listeners, err := decodeListenerFDs(value)
+	if err != nil {
+		return err
+	}
+	inheritedLock.Lock()
+	defer inheritedLock.Unlock()
+	for addr, ln := range listeners {
+		inheritedListeners[addr] = ln
+		log.Printf("Inherited listener %s from the previous process", addr)
+	}
+	return nil
+}
+
+// EncodeListenerFDs returns the ListenerFDsEnv value for listeners on addrs, passed to the new
+// process as consecutive fds starting at firstFD.
+func EncodeListenerFDs(addrs []string, firstFD int) string {
+	pairs := make([]string, len(addrs))
+	for i, addr := range addrs {
+		pairs[i] = strconv.Itoa(firstFD+i) + "=" + addr
+	}
+	return strings.Join(pairs, ",")
+}
+
+// decodeListenerFDs opens the listeners described by a ListenerFDsEnv value, keyed by address.
+// On an error the listeners opened so far are closed again.
+func decodeListenerFDs(value string) (map[string]net.Listener, error) {
+	listeners := make(map[string]net.Listener)
+	fail := func(err error) (map[string]net.Listener, error) {
+		for _, ln := range listeners {
+			ln.Close()
+		}
+		return nil, err
+	}
+	for _, pair := range strings.Split(value, ",") {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			return fail(fmt.Errorf("invalid %s entry %q", ListenerFDsEnv, pair))
+		}
+		fd, err := strconv.Atoi(parts[0])
+		if err != nil {
+			return fail(fmt.Errorf("invalid %s entry %q", ListenerFDsEnv, pair))
+		}
+		f := os.NewFile(uintptr(fd), parts[1])
+		ln, err := net.FileListener(f)
+		f.Close() // FileListener works on a copy
+		if err != nil {
+			return fail(fmt.Errorf("listener %s from fd %d: %w", parts[1], fd, err))
+		}
+		listeners[parts[1]] = ln
+	}
+	return listeners, nil
+}
+
+// WaitAccepting blocks until listeners may accept, see acceptGate.
+func WaitAccepting() {
+	if acceptGate != nil {
+		<-acceptGate
+	}
+}
+
+// ReleaseAccepting lets the listeners accept once the previous process knows we are serving.
+func ReleaseAccepting() {
+	if acceptGate != nil {
+		close(acceptGate)
+	}
+}
+
+// InheritedListener hands out the inherited listener for addr, or nil if there is none.
+// Each listener is handed out once.
+func InheritedListener(addr string) net.Listener {
+	inheritedLock.Lock()
+	defer inheritedLock.Unlock()
+	ln := inheritedListeners[addr]
+	delete(inheritedListeners, addr)
+	return ln
+}
+
+// CloseInheritedListeners closes the inherited listeners nobody asked for, e.g. of tenants
+// removed from the configuration while restarting.
+func CloseInheritedListeners() {
+	inheritedLock.Lock()
+	defer inheritedLock.Unlock()
+	for addr, ln := range inheritedListeners {
+		log.Printf("[WARN] Closing inherited listener %s: no longer configured", addr)
+		_ = ln.Close()
+		delete(inheritedListeners, addr)
+	}
+}
+
+// listen binds addr, or takes over the socket the previous process listened on.
+func listen(addr string) (net.Listener, error) {
+	if ln := InheritedListener(addr); ln != nil {
+		return ln, nil
+	}
+	return net.Listen("tcp", addr)
+}
+
+// ListenerFiles returns copies of the sockets of all current tenant listeners (without TLS,
+// which the new process sets up again) to pass to a restarted process, sorted by address.
+// Listeners still accepting after their removal are not passed on.
+func ListenerFiles() ([]string, []*os.File, error) {
+	globals.TenantsLock.Lock()
+	defer globals.TenantsLock.Unlock()
+
+	addrs := make([]string, 0, len(globals.Listeners))
+	for addr := range globals.Listeners {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+
+	files := make([]*os.File, 0, len(addrs))
+	for _, addr := range addrs {
+		f, err := ListenerFile(listenerInfo[globals.Listeners[addr]].raw)
+		if err != nil {
+			for _, f := range files {
+				f.Close()
+			}
+			return nil, nil, fmt.Errorf("listener %s: %w", addr, err)
+		}
+		files = append(files, f)
+	}
+	return addrs, files, nil
+}
+
+// ListenerFile returns a copy of a TCP listener's socket.
+func ListenerFile(ln net.Listener) (*os.File, error) {
+	tcp, ok := ln.(*net.TCPListener)
+	if !ok {
+		return nil, errors.New("not a TCP listener")
+	}
+	return tcp.File()
+}
@@ -0,0 +1,76 @@
+//go:build linux
+
+package service
+
+import (
+	"syscall"
+	"testing"
+
+	"tcp_sandbox/globals"
+)
+
+func TestEncodeListenerFDs(t *testing.T) {
+	got := EncodeListenerFDs([]string{":3000", "127.0.0.1:8080"}, 4)
+	if want := "4=:3000,5=127.0.0.1:8080"; got != want {
+		t.Errorf("EncodeListenerFDs = %q, want %q", got, want)
+	}
+}
+
+func TestListenerHandoffRoundTrip(t *testing.T) {
+	tenant := withListenerTenant(t)
+	setListeners(tenant, "127.0.0.1:0", "localhost:0")
+
+	addrs, files, err := ListenerFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(addrs) != 2 || addrs[0] != "127.0.0.1:0" || addrs[1] != "localhost:0" {
+		t.Fatalf("ListenerFiles addresses %v, want both listeners sorted", addrs)
+	}
+
+	// the fds the new process would get, here in our own process (not via f.Fd, which would
+	// switch the shared socket, and so the tenant's listener, to blocking mode)
+	const firstFD = 900
+	for i, f := range files {
+		raw, err := f.SyscallConn()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var dupErr error
+		if err := raw.Control(func(fd uintptr) { dupErr = syscall.Dup3(int(fd), firstFD+i, 0) }); err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+		if dupErr != nil {
+			t.Fatal(dupErr)
+		}
+	}
+
+	inherited, err := decodeListenerFDs(EncodeListenerFDs(addrs, firstFD))
+	if err != nil {
+		t.Fatalf("decodeListenerFDs: %v", err)
+	}
+	for _, addr := range addrs {
+		ln := inherited[addr]
+		if ln == nil {
+			t.Errorf("no inherited listener for %s", addr)
+			continue
+		}
+		defer ln.Close()
+
+		globals.TenantsLock.Lock()
+		want := globals.Listeners[addr].Addr().String()
+		globals.TenantsLock.Unlock()
+		if got := ln.Addr().String(); got != want {
+			t.Errorf("inherited listener for %s on %s, want the passed socket on %s", addr, got, want)
+		}
+	}
+}
+
+func TestDecodeListenerFDsRejectsInvalidEntries(t *testing.T) {
+	for _, value := range []string{":3000", "x=:3000", "987654=:3000"} {
+		if _, err := decodeListenerFDs(value); err == nil {
+			t.Errorf("%s=%q accepted", ListenerFDsEnv, value)
+		}
+	}
+}
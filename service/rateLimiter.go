@@ -0,0 +1,179 @@
+package service
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// -----------------------------------------------------------
+// Outbound Rate Limiting (per upstream endpoint)
+// -----------------------------------------------------------
+
+const (
+	defaultRetryAfter = 5 * time.Second // pause when a 429/503 carries no Retry-After
+	maxRetryAfter     = 5 * time.Minute // upper bound for pauses requested by upstream
+)
+
+// endpointLimiter is a token bucket for one upstream endpoint that can also be
+// paused as a whole when the upstream asks us to back off.
+type endpointLimiter struct {
+	mu          sync.Mutex
+	rate        float64 // requests per second, <= 0 disables the bucket
+	burst       int
+	tokens      float64
+	last        time.Time
+	pausedUntil time.Time
+
+	// Throttled messages waiting for the pause to end, oldest first (see throttleQueue.go)
+	queue    []*upstreamMessage
+	flushing bool // a flush goroutine is running
+}
+
+var (
+	endpointLimiters     = make(map[string]*endpointLimiter)
+	endpointLimitersLock sync.Mutex
+)
+
+// getEndpointLimiter returns the shared limiter for an endpoint URL.
+func getEndpointLimiter(endpoint string) *endpointLimiter {
+	endpointLimitersLock.Lock()
+	defer endpointLimitersLock.Unlock()
+
+	l, ok := endpointLimiters[endpoint]
+	if !ok {
+		l = &endpointLimiter{}
+		endpointLimiters[endpoint] = l
+	}
+	return l
+}
+
+// configureEndpointLimiters sets the limit of every endpoint from the tenants posting to it.
+// Tenants sharing an endpoint share its bucket, so the strictest RateLimitPerSec (and the
+// smallest RateLimitBurst among the tenants setting it) applies to all of them; a tenant
+// without a limit cannot bypass it. Caller holds globals.TenantsLock.
+func configureEndpointLimiters() {
+	type limit struct {
+		rate  float64
+		burst int
+	}
+	limits := make(map[string]limit)
+	for _, t := range sortedTenants() {
+		if t.Endpoint == "" || t.RateLimitPerSec <= 0 {
+			continue
+		}
+		burst := t.RateLimitBurst
+		if burst < 1 {
+			burst = 1
+		}
+		cur, ok := limits[t.Endpoint]
+		switch {
+		case !ok || t.RateLimitPerSec < cur.rate:
+			limits[t.Endpoint] = limit{t.RateLimitPerSec, burst}
+		case t.RateLimitPerSec == cur.rate && burst < cur.burst:
+			limits[t.Endpoint] = limit{cur.rate, burst}
+		}
+	}
+
+	for endpoint := range limits {
+		getEndpointLimiter(endpoint) // create limiters not used yet
+	}
+	endpointLimitersLock.Lock()
+	defer endpointLimitersLock.Unlock()
+	for endpoint, l := range endpointLimiters {
+		lim := limits[endpoint]
+		l.mu.Lock()
+		if l.rate != lim.rate || l.burst != lim.burst {
+			if lim.rate > 0 {
+				log.Printf("Rate limit for %s: %g/s, burst %d", endpoint, lim.rate, lim.burst)
+			}
+			l.rate, l.burst = lim.rate, lim.burst
+			l.last = time.Time{} // start with a full bucket
+		}
+		l.mu.Unlock()
+	}
+}
+
+// wait blocks until the endpoint is not paused and a token is available.
+// Without a rate the bucket is disabled, but pauses are still honoured.
+func (l *endpointLimiter) wait() {
+	for {
+		l.mu.Lock()
+		rate, burst := l.rate, l.burst
+		now := time.Now()
+		if now.Before(l.pausedUntil) {
+			d := l.pausedUntil.Sub(now)
+			l.mu.Unlock()
+			time.Sleep(d)
+			continue
+		}
+		if rate <= 0 {
+			l.mu.Unlock()
+			return
+		}
+
+		if l.last.IsZero() {
+			l.tokens = float64(burst)
+		} else {
+			l.tokens += now.Sub(l.last).Seconds() * rate
+			if l.tokens > float64(burst) {
+				l.tokens = float64(burst)
+			}
+		}
+		l.last = now
+
+		if l.tokens >= 1 {
+			l.tokens--
+			l.mu.Unlock()
+			return
+		}
+		d := time.Duration((1 - l.tokens) / rate * float64(time.Second))
+		l.mu.Unlock()
+		time.Sleep(d)
+	}
+}
+
+// pause stops delivery to the endpoint for d (never shortens an existing pause).
+func (l *endpointLimiter) pause(d time.Duration) time.Time {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	until := time.Now().Add(d)
+	if until.After(l.pausedUntil) {
+		l.pausedUntil = until
+	}
+	return l.pausedUntil
+}
+
+// isThrottleStatus reports whether the upstream asked us to slow down.
+func isThrottleStatus(status int) bool {
+	return status == http.StatusTooManyRequests || status == http.StatusServiceUnavailable
+}
+
+// retryAfterDelay parses a Retry-After header (delay-seconds or HTTP-date).
+func retryAfterDelay(header string, now time.Time) time.Duration {
+	header = strings.TrimSpace(header)
+	if header == "" {
+		return defaultRetryAfter
+	}
+
+	var d time.Duration
+	if secs, err := strconv.Atoi(header); err == nil {
+		d = time.Duration(secs) * time.Second
+	} else if at, err := http.ParseTime(header); err == nil {
+		d = at.Sub(now)
+	} else {
+		return defaultRetryAfter
+	}
+
+	if d <= 0 {
+		return time.Second
+	}
+	if d > maxRetryAfter {
+		return maxRetryAfter
+	}
+	return d
+}
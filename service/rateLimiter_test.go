@@ -0,0 +1,87 @@
+package service
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"tcp_sandbox/domain"
+	"tcp_sandbox/globals"
+)
+
+// withTenants replaces globals.Tenants for the duration of the test.
+func withTenants(t *testing.T, tenants ...*domain.Tenant) {
+	globals.TenantsLock.Lock()
+	saved := globals.Tenants
+	globals.Tenants = make(map[string]*domain.Tenant)
+	for _, tenant := range tenants {
+		globals.Tenants[tenant.ID] = tenant
+	}
+	globals.TenantsLock.Unlock()
+
+	t.Cleanup(func() {
+		globals.TenantsLock.Lock()
+		globals.Tenants = saved
+		globals.TenantsLock.Unlock()
+	})
+}
+
+func TestRetryAfterDelay(t *testing.T) {
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		header string
+		want   time.Duration
+	}{
+		{"", defaultRetryAfter},
+		{"  ", defaultRetryAfter},
+		{"7", 7 * time.Second},
+		{" 7 ", 7 * time.Second},
+		{"0", time.Second},
+		{"-3", time.Second},
+		{"86400", maxRetryAfter},
+		{now.Add(90 * time.Second).Format(http.TimeFormat), 90 * time.Second},
+		{now.Add(-time.Minute).Format(http.TimeFormat), time.Second},
+		{"soon", defaultRetryAfter},
+	} {
+		if got := retryAfterDelay(tc.header, now); got != tc.want {
+			t.Errorf("retryAfterDelay(%q) = %s, want %s", tc.header, got, tc.want)
+		}
+	}
+}
+
+func TestEndpointLimitIsSharedAndStrictest(t *testing.T) {
+	const endpoint = "http://rate-limit-test.invalid/in"
+	tenant := func(id string, rate float64, burst int) *domain.Tenant {
+		return &domain.Tenant{TenantConfig: domain.TenantConfig{
+			ID: id, Name: id, Endpoint: endpoint, RateLimitPerSec: rate, RateLimitBurst: burst,
+		}}
+	}
+	withTenants(t, tenant("fast", 50, 5), tenant("slow", 10, 3), tenant("slow-small", 10, 2), tenant("unlimited", 0, 0))
+
+	globals.TenantsLock.Lock()
+	configureEndpointLimiters()
+	globals.TenantsLock.Unlock()
+
+	l := getEndpointLimiter(endpoint)
+	if l.rate != 10 || l.burst != 2 {
+		t.Fatalf("limit = %g/s burst %d, want 10/s burst 2", l.rate, l.burst)
+	}
+
+	// the burst passes at once, the next request waits for a token (100ms at 10/s)
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		l.wait()
+	}
+	if d := time.Since(start); d < 80*time.Millisecond {
+		t.Errorf("3 requests with burst 2 at 10/s took %s, want about 100ms", d)
+	}
+
+	// without tenants limiting it, the endpoint is no longer limited
+	withTenants(t, tenant("unlimited", 0, 0))
+	globals.TenantsLock.Lock()
+	configureEndpointLimiters()
+	globals.TenantsLock.Unlock()
+	if l.rate != 0 {
+		t.Errorf("limit = %g/s after the limiting tenants were removed, want none", l.rate)
+	}
+}
@@ -1,105 +1,110 @@
 package service
 
 import (
-	"encoding/xml"
-	"fmt"
 	"log"
-	"os"
+	"strings"
 	"sync/atomic"
 	"tcp_sandbox/domain"
 	"time"
-
-	"tcp_sandbox/globals"
 )
 
 // -----------------------------------------------------------
 // Tenant Keep-Alive Routine
 // -----------------------------------------------------------
 
-// startKeepAliveRoutine checks if the tenant has KeepAliveIntervalSec > 0
-// and KeepAliveFile != "". If yes, it launches a goroutine that sends
-func StartKeepAliveRoutine(t *domain.Tenant) {
-	if t.KeepAliveIntervalSec <= 0 || t.KeepAliveFile == "" {
-		return
-	}
-
-	interval := time.Duration(t.KeepAliveIntervalSec) * time.Second
-	go func(tenant *domain.Tenant) {
-		ticker := time.NewTicker(interval)
-		defer ticker.Stop()
-
-		for {
-			<-ticker.C
-			sendTenantKeepAlive(tenant)
-		}
-	}(t)
+// keepAliveConfig is the part of a tenant's config a keep-alive send needs,
+// copied under globals.TenantsLock so the send itself runs without it.
+type keepAliveConfig struct {
+	TenantName string
+	Format     string // xml (default), json, text or hex
+	File       string
+	Message    string // inline content, used instead of File
+	Fields     map[string]string
+	WriteBack  bool
+
+	ReplyPattern string // empty if no reply is expected
+	ReplyTimeout time.Duration
+	MaxMissed    int
 }
 
-// sendTenantKeepAlive reads/updates the keep-alive XML file for the tenant,
-// updates <sendTime> to now, writes it back, then sends the XML to all connections.
-// TODO set the keepalive message in the tenant as a field
-func sendTenantKeepAlive(t *domain.Tenant) {
-	globals.TenantsLock.Lock() // to safely read from tenant's fields
-	defer globals.TenantsLock.Unlock()
-
-	ka, err := loadKeepAliveXML(t.KeepAliveFile)
-	if err != nil {
-		log.Printf("[WARN][Tenant %q] Could not load keep-alive file (%s): %v. Creating default.", t.Name, t.KeepAliveFile, err)
-		ka = &domain.KeepAliveXML{
-			TenantName: t.Name,
-		}
+// keepAliveConfigOf snapshots the keep-alive config. Caller holds globals.TenantsLock.
+func keepAliveConfigOf(t *domain.Tenant) keepAliveConfig {
+	cfg := keepAliveConfig{
+		TenantName: t.Name,
+		Format:     strings.ToLower(t.KeepAliveFormat),
+		File:       t.KeepAliveFile,
+		Message:    t.KeepAliveMessage,
+		Fields:     t.KeepAliveFields,
+		WriteBack:  t.KeepAliveWriteBack,
+	}
+	if cfg.Format == "" {
+		cfg.Format = "xml"
 	}
 
-	now := time.Now().UTC().Format(time.RFC3339)
-	ka.TenantName = t.Name
-	ka.SendTime = now
-
-	if wErr := saveKeepAliveXML(t.KeepAliveFile, ka); wErr != nil {
-		log.Printf("[ERROR][Tenant %q] Could not write keep-alive file: %v", t.Name, wErr)
+	cfg.ReplyPattern = t.KeepAliveReplyPattern
+	cfg.ReplyTimeout = time.Duration(t.KeepAliveReplyTimeoutSec) * time.Second
+	if cfg.ReplyTimeout <= 0 {
+		cfg.ReplyTimeout = defaultKeepAliveReplyTimeout
+	}
+	cfg.MaxMissed = t.KeepAliveMaxMissed
+	if cfg.MaxMissed <= 0 {
+		cfg.MaxMissed = defaultKeepAliveMaxMissed
 	}
+	return cfg
+}
+
+// sendTenantKeepAlive renders the tenant's keep-alive and sends it to all connections.
+func sendTenantKeepAlive(t *domain.Tenant, cfg keepAliveConfig) {
+	t.ConnectionsLock.Lock()
+	connCount := len(t.Connections)
+	t.ConnectionsLock.Unlock()
 
-	xmlBytes, marshalErr := xml.MarshalIndent(ka, "", "  ")
-	if marshalErr != nil {
-		log.Printf("[ERROR][Tenant %q] Could not marshal keep-alive XML: %v", t.Name, marshalErr)
+	payload, vars, err := renderKeepAlive(t, cfg, connCount)
+	if err != nil {
+		log.Printf("[ERROR][Tenant %q] Could not render keep-alive: %v", cfg.TenantName, err)
 		return
 	}
 
-	// Frame it with StartByte/EndByte
-	framed := append([]byte{t.StartByte}, append(xmlBytes, t.EndByte)...)
-
 	t.ConnectionsLock.Lock()
 	defer t.ConnectionsLock.Unlock()
-	for _, conn := range t.Connections {
-		if conn == nil {
+	for _, c := range t.Connections {
+		if c == nil {
 			continue
 		}
-		n, err := conn.Write(framed)
-		if err != nil {
-			logError(t, fmt.Errorf("keep-alive write error to %s: %v", conn.RemoteAddr(), err))
-			continue
-		}
-		atomic.AddUint64(&t.BytesSent, uint64(n))
+		writeKeepAlive(t, c, cfg, payload)
 	}
 
-	log.Printf("[Tenant %q] Keep-alive sent at %s to %d connection(s).", t.Name, now, len(t.Connections))
+	log.Printf("[Tenant %q] Keep-alive #%d sent at %s to %d connection(s).", cfg.TenantName, vars.Sequence, vars.SendTime, len(t.Connections))
 }
 
-func loadKeepAliveXML(filePath string) (*domain.KeepAliveXML, error) {
-	data, err := os.ReadFile(filePath)
-	if err != nil {
-		return nil, err
+// renderKeepAlive produces the payload of the tenant's next keep-alive.
+func renderKeepAlive(t *domain.Tenant, cfg keepAliveConfig, connCount int) ([]byte, keepAliveVars, error) {
+	uptime := time.Since(processStart).Truncate(time.Second)
+	vars := keepAliveVars{
+		TenantName:      cfg.TenantName,
+		SendTime:        time.Now().UTC().Format(time.RFC3339),
+		Sequence:        atomic.AddUint64(&t.KeepAliveSeq, 1),
+		ConnectionCount: connCount,
+		Uptime:          uptime.String(),
+		UptimeSec:       int64(uptime / time.Second),
 	}
-	var ka domain.KeepAliveXML
-	if err := xml.Unmarshal(data, &ka); err != nil {
-		return nil, err
+
+	src := inlineKeepAliveTemplate(cfg.Message)
+	if cfg.Message == "" {
+		src = getKeepAliveTemplate(cfg.File)
 	}
-	return &ka, nil
+	payload, err := src.render(cfg.Format, vars, cfg.Fields, cfg.WriteBack)
+	return payload, vars, err
 }
 
-func saveKeepAliveXML(filePath string, ka *domain.KeepAliveXML) error {
-	data, err := xml.MarshalIndent(ka, "", "  ")
-	if err != nil {
-		return err
+// writeKeepAlive queues a rendered keep-alive for one connection and, if configured, waits for its reply.
+// Raw hex keep-alives are sent as is, all other formats are framed with the connection's StartByte/EndByte.
+func writeKeepAlive(t *domain.Tenant, c *domain.Connection, cfg keepAliveConfig, payload []byte) {
+	data := payload
+	if cfg.Format != "hex" {
+		data = frame(c, payload)
+	}
+	if sendFrame(t, c, data) {
+		expectKeepAliveReply(t, c, cfg, time.Now())
 	}
-	return os.WriteFile(filePath, data, 0644)
 }
@@ -0,0 +1,200 @@
+package service
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"tcp_sandbox/domain"
+)
+
+// -----------------------------------------------------------
+// Secret References & Redaction
+// -----------------------------------------------------------
+
+// redactedValue replaces plain secrets in logs and API responses.
+const redactedValue = "***REDACTED***"
+
+// secretRefPattern matches ${env:NAME} and ${file:/path/to/secret} references.
+var secretRefPattern = regexp.MustCompile(`\$\{(env|file):([^}]+)\}`)
+
+// secretField gives uniform access to one secret-bearing config value of a tenant.
+type secretField struct {
+	Path string
+	Get  func() string
+	Set  func(string)
+}
+
+// secretFields lists every config value of the tenant that may hold a secret.
+func secretFields(t *domain.Tenant) []secretField {
+	str := func(path string, p *string) secretField {
+		return secretField{Path: path, Get: func() string { return *p }, Set: func(v string) { *p = v }}
+	}
+
+	fields := []secretField{
+		str("SimpleAuthToken", &t.SimpleAuthToken),
+		str("BearerToken", &t.BearerToken),
+		str("OAuthCredentials.ClientSecret", &t.OAuthCredentials.ClientSecret),
+		str("OAuthCredentials.Password", &t.OAuthCredentials.Password),
+		str("OAuthCredentials.RefreshToken", &t.OAuthCredentials.RefreshToken),
+	}
+	if t.BasicAuth != nil {
+		fields = append(fields, str("BasicAuth.Password", &t.BasicAuth.Password))
+	}
+	if t.APIKey != nil {
+		fields = append(fields, str("APIKey.Value", &t.APIKey.Value))
+	}
+
+	names := make([]string, 0, len(t.ExtraHeaders))
+	for name := range t.ExtraHeaders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		name := name
+		fields = append(fields, secretField{
+			Path: "ExtraHeaders." + name,
+			Get:  func() string { return t.ExtraHeaders[name] },
+			Set:  func(v string) { t.ExtraHeaders[name] = v },
+		})
+	}
+	return fields
+}
+
+// ResolveSecretRefs replaces references and encrypted values in the tenant's secret fields with
+// their values and remembers the originals in t.SecretRefs, so they can be written back on save.
+func ResolveSecretRefs(t *domain.Tenant) error {
+	t.SecretRefs = nil
+	var key []byte
+	keyLoaded := false
+
+	for _, f := range secretFields(t) {
+		raw := f.Get()
+		var resolved string
+		var err error
+		switch {
+		case isEncryptedValue(raw):
+			if !keyLoaded {
+				if key, err = LoadMasterKey(); err != nil {
+					return fmt.Errorf("tenant %q field %s: %w", t.Name, f.Path, err)
+				}
+				keyLoaded = true
+			}
+			resolved, err = decryptSecret(key, raw)
+		case secretRefPattern.MatchString(raw):
+			resolved, err = expandSecretRefs(raw)
+		default:
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("tenant %q field %s: %w", t.Name, f.Path, err)
+		}
+		if t.SecretRefs == nil {
+			t.SecretRefs = make(map[string]string)
+		}
+		t.SecretRefs[f.Path] = raw
+		f.Set(resolved)
+	}
+	return nil
+}
+
+// expandSecretRefs substitutes every reference in s.
+func expandSecretRefs(s string) (string, error) {
+	var firstErr error
+	out := secretRefPattern.ReplaceAllStringFunc(s, func(ref string) string {
+		m := secretRefPattern.FindStringSubmatch(ref)
+		kind, name := m[1], strings.TrimSpace(m[2])
+		switch kind {
+		case "env":
+			v, ok := os.LookupEnv(name)
+			if !ok && firstErr == nil {
+				firstErr = fmt.Errorf("environment variable %s is not set", name)
+			}
+			return v
+		default: // file
+			data, err := os.ReadFile(name)
+			if err != nil && firstErr == nil {
+				firstErr = fmt.Errorf("reading secret file: %w", err)
+			}
+			return strings.TrimRight(string(data), "\r\n")
+		}
+	})
+	return out, firstErr
+}
+
+// MergeSecretRefs updates existing's references for the secret fields a patch has set:
+// a patched reference is remembered, a patched plain value drops the old reference.
+func MergeSecretRefs(existing, patch *domain.Tenant) {
+	for _, f := range secretFields(patch) {
+		if f.Get() == "" {
+			continue
+		}
+		if ref, ok := patch.SecretRefs[f.Path]; ok {
+			if existing.SecretRefs == nil {
+				existing.SecretRefs = make(map[string]string)
+			}
+			existing.SecretRefs[f.Path] = ref
+		} else {
+			delete(existing.SecretRefs, f.Path)
+		}
+	}
+}
+
+// cloneTenant deep-copies the tenant's config (runtime-only fields are not copied, except the
+// secret references). Fields holding slices, maps or pointers must be copied here as well.
+func cloneTenant(t *domain.Tenant) *domain.Tenant {
+	c := &domain.Tenant{TenantConfig: t.TenantConfig}
+	c.Listeners = append([]domain.ListenerConfig(nil), t.Listeners...)
+	c.OAuthCredentials.Scopes = append([]string(nil), t.OAuthCredentials.Scopes...)
+	if t.BasicAuth != nil {
+		basic := *t.BasicAuth
+		c.BasicAuth = &basic
+	}
+	if t.APIKey != nil {
+		apiKey := *t.APIKey
+		c.APIKey = &apiKey
+	}
+	c.ExtraHeaders = cloneStringMap(t.ExtraHeaders)
+	c.KeepAliveFields = cloneStringMap(t.KeepAliveFields)
+	c.SecretRefs = cloneStringMap(t.SecretRefs)
+	return c
+}
+
+func cloneStringMap(m map[string]string) map[string]string {
+	if m == nil {
+		return nil
+	}
+	c := make(map[string]string, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
+
+// persistableTenant returns the copy of a tenant that is written to the config file:
+// referenced secrets are written back as their references.
+func persistableTenant(t *domain.Tenant) *domain.Tenant {
+	c := cloneTenant(t)
+	for _, f := range secretFields(c) {
+		if ref, ok := c.SecretRefs[f.Path]; ok {
+			f.Set(ref)
+		}
+	}
+	return c
+}
+
+// RedactedTenant returns a copy of the tenant that is safe to log or return from the API:
+// referenced secrets show their reference, plain secrets are masked.
+func RedactedTenant(t *domain.Tenant) *domain.Tenant {
+	c := cloneTenant(t)
+	for _, f := range secretFields(c) {
+		if ref, ok := c.SecretRefs[f.Path]; ok {
+			f.Set(ref)
+		} else if f.Get() != "" {
+			f.Set(redactedValue)
+		}
+	}
+	return c
+}
@@ -0,0 +1,125 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+
+	"tcp_sandbox/domain"
+)
+
+// fillValue sets every field reachable from v to a non-zero value.
+func fillValue(t *testing.T, v reflect.Value) {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString("x")
+	case reflect.Bool:
+		v.SetBool(true)
+	case reflect.Int, reflect.Int64:
+		v.SetInt(7)
+	case reflect.Uint8:
+		v.SetUint(7)
+	case reflect.Float64:
+		v.SetFloat(1.5)
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			fillValue(t, v.Field(i))
+		}
+	case reflect.Ptr:
+		v.Set(reflect.New(v.Type().Elem()))
+		fillValue(t, v.Elem())
+	case reflect.Slice:
+		v.Set(reflect.MakeSlice(v.Type(), 1, 1))
+		fillValue(t, v.Index(0))
+	case reflect.Map:
+		v.Set(reflect.MakeMap(v.Type()))
+		key, elem := reflect.New(v.Type().Key()).Elem(), reflect.New(v.Type().Elem()).Elem()
+		fillValue(t, key)
+		fillValue(t, elem)
+		v.SetMapIndex(key, elem)
+	default:
+		t.Fatalf("fillValue: unhandled kind %s (%s), extend the test and cloneTenant", v.Kind(), v.Type())
+	}
+}
+
+func TestCloneTenantIsDeep(t *testing.T) {
+	orig := &domain.Tenant{}
+	fillValue(t, reflect.ValueOf(&orig.TenantConfig).Elem())
+	orig.SecretRefs = map[string]string{"BearerToken": "${env:TOKEN}"}
+
+	c := cloneTenant(orig)
+	if !reflect.DeepEqual(c.TenantConfig, orig.TenantConfig) || !reflect.DeepEqual(c.SecretRefs, orig.SecretRefs) {
+		t.Fatalf("clone differs from the original:\n%+v\n%+v", c.TenantConfig, orig.TenantConfig)
+	}
+
+	// changing anything in the clone must leave the original alone
+	want := &domain.Tenant{SecretRefs: map[string]string{"BearerToken": "${env:TOKEN}"}}
+	fillValue(t, reflect.ValueOf(&want.TenantConfig).Elem())
+	fillDifferent(reflect.ValueOf(&c.TenantConfig).Elem())
+	c.SecretRefs["BearerToken"] = "changed"
+	if !reflect.DeepEqual(orig.TenantConfig, want.TenantConfig) || !reflect.DeepEqual(orig.SecretRefs, want.SecretRefs) {
+		t.Fatalf("clone shares memory with the original")
+	}
+}
+
+// fillDifferent changes the values behind every slice, map and pointer reachable from v in place.
+func fillDifferent(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString("changed")
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			fillDifferent(v.Field(i))
+		}
+	case reflect.Ptr:
+		if !v.IsNil() {
+			fillDifferent(v.Elem())
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			fillDifferent(v.Index(i))
+		}
+	case reflect.Map:
+		for _, key := range v.MapKeys() {
+			elem := reflect.New(v.Type().Elem()).Elem()
+			elem.Set(v.MapIndex(key))
+			fillDifferent(elem)
+			v.SetMapIndex(key, elem)
+		}
+	}
+}
+
+func TestResolveAndRedactSecrets(t *testing.T) {
+	t.Setenv("TCP_SANDBOX_TEST_TOKEN", "s3cret")
+	tenant := &domain.Tenant{TenantConfig: domain.TenantConfig{
+		Name:         "A",
+		BearerToken:  "${env:TCP_SANDBOX_TEST_TOKEN}",
+		ExtraHeaders: map[string]string{"X-Plain": "plain-value"},
+	}}
+	if err := ResolveSecretRefs(tenant); err != nil {
+		t.Fatal(err)
+	}
+	if tenant.BearerToken != "s3cret" {
+		t.Errorf("BearerToken = %q, want the resolved value", tenant.BearerToken)
+	}
+
+	redacted := RedactedTenant(tenant)
+	if redacted.BearerToken != "${env:TCP_SANDBOX_TEST_TOKEN}" {
+		t.Errorf("redacted BearerToken = %q, want the reference", redacted.BearerToken)
+	}
+	if redacted.ExtraHeaders["X-Plain"] != redactedValue {
+		t.Errorf("redacted plain header = %q, want it masked", redacted.ExtraHeaders["X-Plain"])
+	}
+	if persisted := persistableTenant(tenant); persisted.BearerToken != "${env:TCP_SANDBOX_TEST_TOKEN}" ||
+		persisted.ExtraHeaders["X-Plain"] != "plain-value" {
+		t.Errorf("persisted = %q / %q, want the reference and the plain value",
+			persisted.BearerToken, persisted.ExtraHeaders["X-Plain"])
+	}
+	if tenant.BearerToken != "s3cret" {
+		t.Errorf("redacting changed the tenant")
+	}
+
+	unset := &domain.Tenant{TenantConfig: domain.TenantConfig{Name: "B", BearerToken: "${env:TCP_SANDBOX_TEST_UNSET}"}}
+	if err := ResolveSecretRefs(unset); err == nil {
+		t.Errorf("unset environment variable resolved without error")
+	}
+}
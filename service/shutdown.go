@@ -0,0 +1,159 @@
+package service
+
+import (
+	"errors"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"tcp_sandbox/domain"
+	"tcp_sandbox/globals"
+)
+
+// -----------------------------------------------------------
+// Graceful Shutdown & Connection Draining
+// -----------------------------------------------------------
+
+const (
+	defaultDrainTimeout = 10 * time.Second
+	drainPollInterval   = 50 * time.Millisecond
+)
+
+// shuttingDown is set to 1 once Shutdown started; no listeners are started after that.
+var shuttingDown int32
+
+// Shutdown stops accepting on all tenant listeners and drains every tenant's connections,
+// in-flight upstream calls and throttled messages until the deadline.
+func Shutdown(timeout time.Duration) {
+	BeginShutdown()
+	deadline := time.Now().Add(timeout)
+
+	globals.TenantsLock.Lock()
+	tenants := sortedTenants()
+	for _, t := range tenants {
+		closeTenantListeners(t)
+	}
+	globals.TenantsLock.Unlock()
+
+	var wg sync.WaitGroup
+	for _, t := range tenants {
+		wg.Add(1)
+		go func(t *domain.Tenant) {
+			defer wg.Done()
+			drainTenant(t, deadline)
+		}(t)
+	}
+	wg.Wait()
+	drainThrottleQueues(deadline)
+}
+
+// BeginShutdown stops starting listeners (e.g. on a file reload), ahead of Shutdown.
+func BeginShutdown() {
+	atomic.StoreInt32(&shuttingDown, 1)
+}
+
+func isShuttingDown() bool {
+	return atomic.LoadInt32(&shuttingDown) == 1
+}
+
+// tenantDrainTimeout is how long a removed tenant's connections may take to drain.
+func tenantDrainTimeout(t *domain.Tenant) time.Duration {
+	if t.DrainTimeoutSec > 0 {
+		return time.Duration(t.DrainTimeoutSec) * time.Second
+	}
+	return defaultDrainTimeout
+}
+
+// drainTenant drains all open connections of a tenant whose listeners are closed, including
+// connections still being accepted (see addConnection), then waits for its remaining upstream
+// calls until the deadline.
+func drainTenant(t *domain.Tenant, deadline time.Time) {
+	goodbye := t.GoodbyeMessage
+	t.ConnectionsLock.Lock()
+	t.DrainDeadline, t.DrainGoodbye = deadline, goodbye
+	conns := append([]*domain.Connection(nil), t.Connections...)
+	t.ConnectionsLock.Unlock()
+
+	if len(conns) > 0 {
+		log.Printf("[Tenant %q] Draining %d connection(s), deadline %s", t.Name, len(conns), deadline.Format(time.RFC3339))
+	}
+	var wg sync.WaitGroup
+	for _, c := range conns {
+		wg.Add(1)
+		go func(c *domain.Connection) {
+			defer wg.Done()
+			drainConnection(t, c, goodbye, deadline)
+		}(c)
+	}
+	wg.Wait()
+
+	closed := func() bool { return connectionCount(t) == 0 }
+	if !waitUntil(deadline, nil, closed) {
+		log.Printf("[WARN][Tenant %q] %d connection(s) still open at the drain deadline", t.Name, connectionCount(t))
+	}
+	inFlight := func() bool { return atomic.LoadInt64(&t.InFlight) == 0 }
+	if !waitUntil(deadline, nil, inFlight) {
+		log.Printf("[WARN][Tenant %q] %d upstream call(s) still in flight at the drain deadline",
+			t.Name, atomic.LoadInt64(&t.InFlight))
+	}
+}
+
+// drainConnection stops reading from a connection at its next frame boundary, sends the goodbye
+// frame, waits until the queued frames are written (at most until the deadline) and closes it.
+func drainConnection(t *domain.Tenant, c *domain.Connection, goodbye string, deadline time.Time) {
+	atomic.StoreInt64(&c.DrainDeadline, deadline.UnixNano())
+	atomic.StoreInt32(&c.Draining, 1)
+	c.Conn.SetReadDeadline(time.Now()) // wakes the handler; it finishes a started frame first
+
+	timer := time.NewTimer(time.Until(deadline))
+	select {
+	case <-c.ReaderDone:
+	case <-timer.C:
+	}
+	timer.Stop()
+
+	if goodbye != "" {
+		sendFrame(t, c, frame(c, []byte(goodbye)))
+	}
+	flushed := func() bool { return atomic.LoadInt64(&c.Pending) == 0 }
+	if !waitUntil(deadline, c.Closed, flushed) {
+		log.Printf("[WARN][Tenant %q] Closing %s with %d unsent frame(s) at the drain deadline",
+			t.Name, c.Conn.RemoteAddr(), atomic.LoadInt64(&c.Pending))
+	}
+	closeConnection(t, c)
+}
+
+// waitUntil polls done until it returns true, the deadline passes or abort is closed.
+// It reports whether done returned true.
+func waitUntil(deadline time.Time, abort <-chan struct{}, done func() bool) bool {
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+	for !done() {
+		if !time.Now().Before(deadline) {
+			return false
+		}
+		select {
+		case <-abort:
+			return done()
+		case <-ticker.C:
+		}
+	}
+	return true
+}
+
+func connectionCount(t *domain.Tenant) int {
+	t.ConnectionsLock.Lock()
+	defer t.ConnectionsLock.Unlock()
+	return len(t.Connections)
+}
+
+func isDraining(c *domain.Connection) bool {
+	return atomic.LoadInt32(&c.Draining) == 1
+}
+
+func isTimeout(err error) bool {
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
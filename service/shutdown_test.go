@@ -0,0 +1,70 @@
+package service
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"tcp_sandbox/domain"
+)
+
+// connectTestClient registers a connection like the accept loop does and returns the client side.
+func connectTestClient(t *testing.T, tenant *domain.Tenant) net.Conn {
+	server, client := net.Pipe()
+	t.Cleanup(func() { client.Close() })
+	c := newConnection(tenant, "test", server)
+	addConnection(tenant, c)
+	go handleConnection(c, tenant)
+	go runConnectionWriter(tenant, c)
+	return client
+}
+
+// readUntilClosed returns everything the server sends until it closes the connection.
+func readUntilClosed(t *testing.T, client net.Conn) string {
+	client.SetReadDeadline(time.Now().Add(3 * time.Second))
+	data, err := io.ReadAll(client)
+	if err != nil {
+		t.Fatalf("connection not closed by the drain: %v (got %q)", err, data)
+	}
+	return string(data)
+}
+
+func newDrainTestTenant() *domain.Tenant {
+	return &domain.Tenant{TenantConfig: domain.TenantConfig{
+		ID: "drain", Name: "drain", StartByte: 2, EndByte: 3, GoodbyeMessage: "BYE",
+	}}
+}
+
+func TestDrainSendsGoodbyeAndCloses(t *testing.T) {
+	tenant := newDrainTestTenant()
+	client := connectTestClient(t, tenant)
+
+	done := make(chan struct{})
+	go func() {
+		drainTenant(tenant, time.Now().Add(2*time.Second))
+		close(done)
+	}()
+	if got := readUntilClosed(t, client); got != "\x02BYE\x03" {
+		t.Errorf("client got %q, want the goodbye frame", got)
+	}
+	select {
+	case <-done:
+	case <-time.After(3 * time.Second):
+		t.Fatal("drainTenant did not return")
+	}
+	if n := connectionCount(tenant); n != 0 {
+		t.Errorf("%d connection(s) left after the drain", n)
+	}
+}
+
+func TestDrainCoversConnectionsAddedLate(t *testing.T) {
+	tenant := newDrainTestTenant()
+	drainTenant(tenant, time.Now().Add(2*time.Second)) // nothing to drain yet
+
+	// accepted just before the listener closed
+	client := connectTestClient(t, tenant)
+	if got := readUntilClosed(t, client); got != "\x02BYE\x03" {
+		t.Errorf("late client got %q, want the goodbye frame", got)
+	}
+}
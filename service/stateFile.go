@@ -0,0 +1,115 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"tcp_sandbox/domain"
+	"tcp_sandbox/globals"
+)
+
+// -----------------------------------------------------------
+// State File (runtime counters, kept apart from the config)
+// -----------------------------------------------------------
+
+// StateFileEnv names the optional file runtime counters are persisted to.
+const StateFileEnv = "TCP_SANDBOX_STATE_FILE"
+
+// stateSaveInterval is how often the state file is written.
+const stateSaveInterval = 30 * time.Second
+
+// stateFile is the content of the state file.
+type stateFile struct {
+	Saved   time.Time
+	Tenants map[string]tenantStateEntry // keyed by tenant ID
+}
+
+type tenantStateEntry struct {
+	Name string
+	domain.TenantCounters
+}
+
+// LoadTenantState restores the counters of the loaded tenants from the state file.
+// A missing file is not an error. Call before the tenants are started.
+func LoadTenantState(filename string) error {
+	data, err := os.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var st stateFile
+	if err := json.Unmarshal(data, &st); err != nil {
+		return fmt.Errorf("json unmarshal error: %w", err)
+	}
+
+	globals.TenantsLock.Lock()
+	defer globals.TenantsLock.Unlock()
+
+	restored := 0
+	for key, entry := range st.Tenants {
+		t, ok := globals.Tenants[key]
+		if !ok {
+			t = TenantByPort(globals.Tenants, key) // state files of older versions are keyed by port
+		}
+		if t != nil {
+			t.TenantCounters = entry.TenantCounters
+			restored++
+		}
+	}
+	log.Printf("Restored counters of %d tenant(s) from %s (saved %s)", restored, filename, st.Saved.Format(time.RFC3339))
+	return nil
+}
+
+// SaveTenantState writes the counters of all tenants to the state file.
+func SaveTenantState(filename string) error {
+	st := stateFile{Saved: time.Now().UTC(), Tenants: make(map[string]tenantStateEntry)}
+
+	globals.TenantsLock.Lock()
+	for id, t := range globals.Tenants {
+		st.Tenants[id] = tenantStateEntry{Name: t.Name, TenantCounters: loadCounters(t)}
+	}
+	globals.TenantsLock.Unlock()
+
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return fmt.Errorf("json marshal error: %w", err)
+	}
+	return writeFileAtomic(filename, data, 0644)
+}
+
+// StartStateSaver writes the state file periodically.
+func StartStateSaver(filename string) {
+	ticker := time.NewTicker(stateSaveInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if isShuttingDown() {
+			return // saved once more at the end, or owned by the restarted process
+		}
+		if err := SaveTenantState(filename); err != nil {
+			log.Printf("[ERROR] Could not save state file: %v", err)
+		}
+	}
+}
+
+// loadCounters reads a consistent-enough copy of the tenant's counters.
+func loadCounters(t *domain.Tenant) domain.TenantCounters {
+	return domain.TenantCounters{
+		BytesReceived:  atomic.LoadUint64(&t.BytesReceived),
+		BytesSent:      atomic.LoadUint64(&t.BytesSent),
+		Errors:         atomic.LoadUint64(&t.Errors),
+		Throttled:      atomic.LoadUint64(&t.Throttled),
+		AuthFailures:   atomic.LoadUint64(&t.AuthFailures),
+		UpstreamErrors: atomic.LoadUint64(&t.UpstreamErrors),
+		Messages:       atomic.LoadUint64(&t.Messages),
+		Heartbeats:     atomic.LoadUint64(&t.Heartbeats),
+
+		ThrottleDropped: atomic.LoadUint64(&t.ThrottleDropped),
+	}
+}
@@ -0,0 +1,172 @@
+package service
+
+import (
+	"crypto/sha256"
+	"errors"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"tcp_sandbox/globals"
+)
+
+// -----------------------------------------------------------
+// Tenant File Watcher (reload on change, only rewrites the file to migrate it)
+// -----------------------------------------------------------
+
+const (
+	// reloadDebounce lets editors finish writing (truncate, write, rename) before we read the file.
+	reloadDebounce = 500 * time.Millisecond
+	// pollInterval is used when file events are not available.
+	pollInterval = 2 * time.Second
+	// safetyPollInterval catches changes the event watcher may miss (e.g. network file systems).
+	safetyPollInterval = 1 * time.Minute
+)
+
+var (
+	tenantsFileHash [sha256.Size]byte // content last loaded from or written to the tenants file
+	tenantsFileLock sync.Mutex
+)
+
+// rememberTenantsFile records the content the running config corresponds to.
+func rememberTenantsFile(data []byte) {
+	tenantsFileLock.Lock()
+	tenantsFileHash = sha256.Sum256(data)
+	tenantsFileLock.Unlock()
+}
+
+// Reload modes of the tenants file
+const (
+	ReloadWatch = "watch" // file system events, falling back to polling
+	ReloadPoll  = "poll"  // poll mtime and size
+	ReloadOff   = "off"   // only load at startup
+)
+
+// StartTenantFileManager reloads the tenants file whenever its content changes. In watch mode it
+// uses file system events where available (inotify on Linux) and falls back to polling mtime and size.
+func StartTenantFileManager(filename, mode string) {
+	var events <-chan struct{}
+	interval := pollInterval
+	switch mode {
+	case ReloadOff:
+		log.Printf("Reloading %s is disabled", filename)
+		return
+	case ReloadPoll:
+		log.Printf("Polling %s for changes every %s", filename, pollInterval)
+	default:
+		var err error
+		if events, err = watchFile(filename); err != nil {
+			log.Printf("[WARN] Cannot watch %s (%v), polling every %s", filename, err, pollInterval)
+		} else {
+			interval = safetyPollInterval
+			log.Printf("Watching %s for changes", filename)
+		}
+	}
+
+	poll := time.NewTicker(interval)
+	defer poll.Stop()
+	lastStat := statFile(filename)
+
+	debounce := time.NewTimer(time.Hour)
+	debounce.Stop()
+
+	for {
+		select {
+		case _, ok := <-events:
+			if !ok {
+				log.Printf("[WARN] File watcher for %s stopped, polling every %s", filename, pollInterval)
+				events = nil
+				poll.Reset(pollInterval)
+				continue
+			}
+			debounce.Reset(reloadDebounce)
+		case <-poll.C:
+			if st := statFile(filename); st != lastStat {
+				lastStat = st
+				debounce.Reset(reloadDebounce)
+			}
+		case <-debounce.C:
+			lastStat = statFile(filename)
+			reloadTenantsFile(filename)
+		}
+	}
+}
+
+// fileStat is what the polling fallback compares.
+type fileStat struct {
+	modTime time.Time
+	size    int64
+}
+
+func statFile(filename string) fileStat {
+	fi, err := os.Stat(filename)
+	if err != nil {
+		return fileStat{}
+	}
+	return fileStat{modTime: fi.ModTime(), size: fi.Size()}
+}
+
+// reloadTenantsFile applies the tenants file if its content differs from the running config.
+func reloadTenantsFile(filename string) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		log.Printf("[ERROR] Could not read tenants file: %v", err)
+		return
+	}
+
+	hash := sha256.Sum256(data)
+	tenantsFileLock.Lock()
+	unchanged := hash == tenantsFileHash
+	tenantsFileLock.Unlock()
+	if unchanged {
+		return
+	}
+
+	log.Printf("Tenants file %s changed, reloading", filename)
+	migrated, err := applyTenantsConfig(data, SourceFile)
+	if err != nil {
+		logConfigError("Tenants file rejected", err)
+		// Not remembered: an unchanged invalid file is re-checked, but fixing it triggers a reload
+		return
+	}
+	if migrated {
+		saveMigratedTenants(filename)
+	}
+	printAllTenantsStatus()
+}
+
+// applyTenantsConfig validates a tenants config and applies it live, recording the change.
+// File reloads and rollbacks both go through here. It reports whether tenants had to be migrated
+// from an older format; the caller then rewrites the file (see saveMigratedTenants).
+func applyTenantsConfig(data []byte, source string) (bool, error) {
+	fileTenants, migrated, err := checkTenantsConfig(data)
+	if err != nil {
+		return false, err
+	}
+
+	// One lock hold, so a concurrent patch is not attributed to this change
+	globals.TenantsLock.Lock()
+	before := SnapshotTenants()
+	mergeTenants(fileTenants)
+	SyncListeners()
+	after := SnapshotTenants()
+	globals.TenantsLock.Unlock()
+
+	rememberTenantsFile(data)
+	RecordConfigChange(source, before, after)
+	return migrated, nil
+}
+
+// logConfigError logs a rejected config, one line per validation error.
+func logConfigError(msg string, err error) {
+	var cfgErr *ConfigError
+	if !errors.As(err, &cfgErr) {
+		log.Printf("[ERROR] %s: %v", msg, err)
+		return
+	}
+	log.Printf("[ERROR] %s, keeping the running config:", msg)
+	for _, is := range cfgErr.Report.Errors {
+		log.Printf("[ERROR]   %s", FormatIssue(is))
+	}
+}
@@ -0,0 +1,79 @@
+package service
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"tcp_sandbox/domain"
+	"tcp_sandbox/globals"
+)
+
+func TestReloadIgnoresOwnWritesAndUnchangedContent(t *testing.T) {
+	// a free port; the validator does not accept port 0
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	tenant := &domain.Tenant{TenantConfig: domain.TenantConfig{
+		ID: "a", Name: "A", Comment: "saved", StartByte: 2, EndByte: 3,
+		Listeners: listeners(addr), Endpoint: "http://upstream.invalid/in", AuthType: "none",
+	}}
+	withTenants(t, tenant)
+	t.Cleanup(func() {
+		globals.TenantsLock.Lock()
+		for _, tenant := range globals.Tenants {
+			closeTenantListeners(tenant)
+		}
+		globals.TenantsLock.Unlock()
+	})
+	comment := func() string {
+		globals.TenantsLock.Lock()
+		defer globals.TenantsLock.Unlock()
+		return globals.Tenants["a"].Comment
+	}
+	setComment := func(c string) {
+		globals.TenantsLock.Lock()
+		tenant.Comment = c
+		globals.TenantsLock.Unlock()
+	}
+
+	path := filepath.Join(t.TempDir(), "tenants.json")
+	if err := SaveTenantsToFile(path); err != nil {
+		t.Fatal(err)
+	}
+	// a reload would take the file's comment back
+	setComment("live")
+
+	reloadTenantsFile(path) // our own write
+	if got := comment(); got != "live" {
+		t.Errorf("own write reloaded (Comment %q)", got)
+	}
+
+	// a new mtime without a content change (e.g. touch, or an editor saving unchanged)
+	data, _ := os.ReadFile(path)
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	reloadTenantsFile(path)
+	if got := comment(); got != "live" {
+		t.Errorf("unchanged content reloaded (Comment %q)", got)
+	}
+
+	// an edit by someone else is applied
+	edited := strings.Replace(string(data), `"Comment": "saved"`, `"Comment": "edited"`, 1)
+	if err := os.WriteFile(path, []byte(edited), 0644); err != nil {
+		t.Fatal(err)
+	}
+	reloadTenantsFile(path)
+	if got := comment(); got != "edited" {
+		t.Errorf("Comment %q after an external edit, want edited", got)
+	}
+}
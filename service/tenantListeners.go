@@ -0,0 +1,302 @@
+package service
+
+import (
+	"crypto/tls"
+	"errors"
+	"log"
+	"net"
+	"sort"
+	"syscall"
+	"time"
+
+	"tcp_sandbox/domain"
+	"tcp_sandbox/globals"
+)
+
+// -----------------------------------------------------------
+// Tenant Listeners (one per endpoint, TLS, overlap on removal)
+// -----------------------------------------------------------
+
+const defaultListenerOverlap = 30 * time.Second
+
+// tenantListener is what we know about a listener besides its address.
+type tenantListener struct {
+	cfg    domain.ListenerConfig
+	tenant *domain.Tenant
+	raw    net.Listener // the TCP listener below TLS, passed on when restarting
+	retire *time.Timer  // set while the listener is still accepting after its removal
+}
+
+// listenerInfo covers current and retiring listeners. Guarded by globals.TenantsLock.
+var listenerInfo = make(map[net.Listener]*tenantListener)
+
+// syncTenantListeners starts listeners for the tenant's endpoints and retires the listeners of
+// endpoints it no longer declares. Caller holds globals.TenantsLock.
+func syncTenantListeners(t *domain.Tenant) {
+	if isShuttingDown() {
+		return
+	}
+	for _, cfg := range t.Listeners {
+		syncListener(t, cfg)
+	}
+	for addr, ln := range globals.Listeners {
+		if info := listenerInfo[ln]; info != nil && info.tenant == t && !declaresListener(t, addr) {
+			delete(globals.Listeners, addr)
+			retireListener(ln)
+		}
+	}
+}
+
+// syncListener makes sure the tenant accepts on one endpoint. A listener is only restarted if
+// its TLS settings changed; framing overrides apply without one. Caller holds globals.TenantsLock.
+func syncListener(t *domain.Tenant, cfg domain.ListenerConfig) {
+	addr := cfg.Address
+	if ln, ok := globals.Listeners[addr]; ok {
+		info := listenerInfo[ln]
+		switch {
+		case info.tenant == t && sameTLS(info.cfg, cfg):
+			info.cfg = cfg
+			return
+		case info.tenant == t:
+			log.Printf("[Tenant %q] TLS settings of %s changed, restarting listener", t.Name, addr)
+		case isActiveTenant(info.tenant) && declaresListener(info.tenant, addr):
+			log.Printf("[ERROR] Cannot listen for tenant %q on %s: used by tenant %q", t.Name, addr, info.tenant.Name)
+			return
+		default:
+			log.Printf("Listener %s moves from tenant %q to %q", addr, info.tenant.Name, t.Name)
+		}
+		delete(globals.Listeners, addr)
+		closeListener(ln)
+	} else if ln, info := retiringListener(addr); ln != nil {
+		if info.tenant == t && sameTLS(info.cfg, cfg) {
+			// endpoint added back during the overlap
+			info.retire.Stop()
+			info.retire = nil
+			info.cfg = cfg
+			globals.Listeners[addr] = ln
+			log.Printf("[Tenant %q] Keeping listener %s", t.Name, addr)
+			return
+		}
+		closeListener(ln)
+	}
+
+	err := startTenantListener(t, cfg)
+	if errors.Is(err, syscall.EADDRINUSE) && closeConflictingListeners(t, addr) {
+		// e.g. ":3000" replaced by "127.0.0.1:3000": both listeners cannot overlap
+		log.Printf("[WARN][Tenant %q] %s conflicts with a removed listener, switching without overlap", t.Name, addr)
+		err = startTenantListener(t, cfg)
+	}
+	if err != nil {
+		log.Printf("[ERROR] Failed to start listener for tenant %q on %s: %v", t.Name, addr, err)
+	}
+}
+
+// startTenantListener begins listening on one endpoint. Keep-alives are managed separately by
+// syncKeepAlive. Caller holds globals.TenantsLock.
+func startTenantListener(t *domain.Tenant, cfg domain.ListenerConfig) error {
+	var tlsConfig *tls.Config
+	if cfg.TLSCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.TLSCertFile, cfg.TLSKeyFile)
+		if err != nil {
+			return err
+		}
+		tlsConfig = &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	}
+
+	addr := cfg.Address
+	raw, err := listen(addr)
+	if err != nil {
+		return err
+	}
+	ln, mode := raw, ""
+	if tlsConfig != nil {
+		ln = tls.NewListener(ln, tlsConfig)
+		mode = " (TLS)"
+	}
+	globals.Listeners[addr] = ln
+	listenerInfo[ln] = &tenantListener{cfg: cfg, tenant: t, raw: raw}
+
+	log.Printf("Listening for tenant %q on %s%s", t.Name, addr, mode)
+
+	go func() {
+		WaitAccepting()
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				log.Printf("Listener stopped on %s (tenant %q). Err: %v", addr, t.Name, err)
+				return
+			}
+			c := newConnection(t, addr, conn)
+			addConnection(t, c)
+			log.Printf("Accepted connection from %s for tenant %q (%s)", conn.RemoteAddr(), t.Name, addr)
+			go handleConnection(c, t)
+			go runConnectionWriter(t, c)
+			go runIdleKeepAlive(t, c)
+		}
+	}()
+	return nil
+}
+
+// retireListener keeps the listener of a removed endpoint accepting for the tenant's overlap
+// period, so clients can move over; open connections are not affected.
+// Caller holds globals.TenantsLock.
+func retireListener(ln net.Listener) {
+	info := listenerInfo[ln]
+	t := info.tenant
+	overlap := time.Duration(t.ListenerOverlapSec) * time.Second
+	if overlap <= 0 {
+		overlap = defaultListenerOverlap
+	}
+	log.Printf("[Tenant %q] Old listener %s keeps accepting for %s", t.Name, info.cfg.Address, overlap)
+
+	info.retire = time.AfterFunc(overlap, func() {
+		globals.TenantsLock.Lock()
+		defer globals.TenantsLock.Unlock()
+		if cur, ok := listenerInfo[ln]; ok && cur.retire != nil {
+			log.Printf("[Tenant %q] Overlap ended, closing old listener %s", t.Name, info.cfg.Address)
+			closeListener(ln)
+		}
+	})
+}
+
+// closeConflictingListeners closes the tenant's removed listeners on the port of addr, so addr
+// can be bound. It reports whether there were any. Caller holds globals.TenantsLock.
+func closeConflictingListeners(t *domain.Tenant, addr string) bool {
+	_, port, _ := net.SplitHostPort(addr)
+	closed := false
+	for ln, info := range listenerInfo {
+		_, p, _ := net.SplitHostPort(info.cfg.Address)
+		if info.tenant != t || p != port || declaresListener(t, info.cfg.Address) {
+			continue
+		}
+		if globals.Listeners[info.cfg.Address] == ln {
+			delete(globals.Listeners, info.cfg.Address)
+		}
+		closeListener(ln)
+		closed = true
+	}
+	return closed
+}
+
+// closeListener closes a listener and forgets it. Caller holds globals.TenantsLock.
+func closeListener(ln net.Listener) {
+	if info, ok := listenerInfo[ln]; ok && info.retire != nil {
+		info.retire.Stop()
+	}
+	delete(listenerInfo, ln)
+	_ = ln.Close()
+}
+
+// StopTenantListeners closes all listeners of a removed tenant and drains its open connections
+// in the background (see drainTenant) for up to DrainTimeoutSec. Caller holds globals.TenantsLock.
+func StopTenantListeners(t *domain.Tenant) {
+	closeTenantListeners(t)
+	go drainTenant(t, time.Now().Add(tenantDrainTimeout(t)))
+}
+
+// closeTenantListeners closes all listeners of a tenant, including listeners still accepting
+// after their removal. Caller holds globals.TenantsLock.
+func closeTenantListeners(t *domain.Tenant) {
+	for ln, info := range listenerInfo {
+		if info.tenant != t {
+			continue
+		}
+		log.Printf("Stopping listener on %s (tenant %q)", info.cfg.Address, t.Name)
+		if globals.Listeners[info.cfg.Address] == ln {
+			delete(globals.Listeners, info.cfg.Address)
+		}
+		closeListener(ln)
+	}
+}
+
+// stopOrphanedListeners closes listeners whose tenant is no longer configured.
+// Caller holds globals.TenantsLock.
+func stopOrphanedListeners() {
+	for ln, info := range listenerInfo {
+		if isActiveTenant(info.tenant) {
+			continue
+		}
+		log.Printf("Stopping listener on %s (removed tenant)", info.cfg.Address)
+		if globals.Listeners[info.cfg.Address] == ln {
+			delete(globals.Listeners, info.cfg.Address)
+		}
+		closeListener(ln)
+	}
+}
+
+func sameTLS(a, b domain.ListenerConfig) bool {
+	return a.TLSCertFile == b.TLSCertFile && a.TLSKeyFile == b.TLSKeyFile
+}
+
+// declaresListener reports whether addr is one of the tenant's configured endpoints.
+func declaresListener(t *domain.Tenant, addr string) bool {
+	for _, l := range t.Listeners {
+		if l.Address == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// retiringListener returns the listener still accepting on addr after its removal, if any.
+// Caller holds globals.TenantsLock.
+func retiringListener(addr string) (net.Listener, *tenantListener) {
+	for ln, info := range listenerInfo {
+		if info.retire != nil && info.cfg.Address == addr {
+			return ln, info
+		}
+	}
+	return nil, nil
+}
+
+// hasListener reports whether the tenant has a current or retiring listener on addr.
+// Caller holds globals.TenantsLock.
+func hasListener(t *domain.Tenant, addr string) bool {
+	for _, info := range listenerInfo {
+		if info.tenant == t && info.cfg.Address == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// isActiveTenant reports whether t is (still) configured. Caller holds globals.TenantsLock.
+func isActiveTenant(t *domain.Tenant) bool {
+	other, ok := globals.Tenants[t.ID]
+	return ok && other == t && t.Name != ""
+}
+
+// listenAddresses lists the addresses a tenant accepts on: its configured listeners in order,
+// then listeners still accepting after their removal. Caller holds globals.TenantsLock.
+func listenAddresses(t *domain.Tenant) []string {
+	var out, retiring []string
+	for _, l := range t.Listeners {
+		if ln, ok := globals.Listeners[l.Address]; ok && listenerInfo[ln] != nil && listenerInfo[ln].tenant == t {
+			out = append(out, l.Address)
+		}
+	}
+	for _, info := range listenerInfo {
+		if info.tenant == t && info.retire != nil {
+			retiring = append(retiring, info.cfg.Address)
+		}
+	}
+	sort.Strings(retiring)
+	return append(out, retiring...)
+}
+
+// TenantByPort returns the only one of tenants listening on port, for clients that still
+// identify tenants by port. Caller holds globals.TenantsLock if tenants is globals.Tenants.
+func TenantByPort(tenants map[string]*domain.Tenant, port string) *domain.Tenant {
+	var found *domain.Tenant
+	for _, t := range tenants {
+		for _, l := range t.Listeners {
+			if _, p, err := net.SplitHostPort(l.Address); err == nil && p == port {
+				if found != nil && found != t {
+					return nil // ambiguous
+				}
+				found = t
+			}
+		}
+	}
+	return found
+}
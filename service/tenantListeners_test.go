@@ -0,0 +1,115 @@
+package service
+
+import (
+	"net"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"tcp_sandbox/domain"
+	"tcp_sandbox/globals"
+)
+
+// withListenerTenant configures a tenant and closes all its listeners after the test.
+func withListenerTenant(t *testing.T, addrs ...string) *domain.Tenant {
+	tenant := &domain.Tenant{TenantConfig: domain.TenantConfig{
+		ID: "listen", Name: "listen", StartByte: 2, EndByte: 3, ListenerOverlapSec: 60, Listeners: listeners(addrs...),
+	}}
+	withTenants(t, tenant)
+	t.Cleanup(func() {
+		globals.TenantsLock.Lock()
+		closeTenantListeners(tenant)
+		globals.TenantsLock.Unlock()
+	})
+	return tenant
+}
+
+// setListeners changes the tenant's endpoints like a reload does.
+func setListeners(tenant *domain.Tenant, addrs ...string) {
+	globals.TenantsLock.Lock()
+	defer globals.TenantsLock.Unlock()
+	tenant.Listeners = listeners(addrs...)
+	syncTenantListeners(tenant)
+}
+
+func tenantListeners(tenant *domain.Tenant) []string {
+	globals.TenantsLock.Lock()
+	defer globals.TenantsLock.Unlock()
+	return listenAddresses(tenant)
+}
+
+func currentListener(addr string) net.Listener {
+	globals.TenantsLock.Lock()
+	defer globals.TenantsLock.Unlock()
+	return globals.Listeners[addr]
+}
+
+func TestRemovedListenerOverlapsAndIsKeptWhenAddedBack(t *testing.T) {
+	const oldAddr, newAddr = "127.0.0.1:0", "localhost:0"
+	tenant := withListenerTenant(t, oldAddr)
+
+	setListeners(tenant, oldAddr)
+	old := currentListener(oldAddr)
+	if old == nil {
+		t.Fatalf("no listener on %s", oldAddr)
+	}
+
+	// the removed endpoint keeps accepting during the overlap
+	setListeners(tenant, newAddr)
+	if got, want := tenantListeners(tenant), []string{newAddr, oldAddr}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("listeners %v, want %v", got, want)
+	}
+	if currentListener(oldAddr) != nil {
+		t.Errorf("removed endpoint %s still current", oldAddr)
+	}
+	conn, err := net.Dial("tcp", old.Addr().String())
+	if err != nil {
+		t.Fatalf("retiring listener does not accept: %v", err)
+	}
+	conn.Close()
+
+	// added back during the overlap: the same listener is kept, the other one retires
+	setListeners(tenant, oldAddr)
+	if currentListener(oldAddr) != old {
+		t.Errorf("listener on %s restarted instead of kept", oldAddr)
+	}
+	if got, want := tenantListeners(tenant), []string{oldAddr, newAddr}; !reflect.DeepEqual(got, want) {
+		t.Errorf("listeners %v, want %v", got, want)
+	}
+	globals.TenantsLock.Lock()
+	retiring := listenerInfo[old].retire
+	globals.TenantsLock.Unlock()
+	if retiring != nil {
+		t.Errorf("kept listener still scheduled to close")
+	}
+}
+
+func TestListenerOnSamePortReplacesRemovedOneWithoutOverlap(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := strconv.Itoa(ln.Addr().(*net.TCPAddr).Port)
+	ln.Close()
+	wildcard, loopback := ":"+port, "127.0.0.1:"+port
+
+	tenant := withListenerTenant(t, wildcard)
+	setListeners(tenant, wildcard)
+	if currentListener(wildcard) == nil {
+		t.Fatalf("no listener on %s", wildcard)
+	}
+
+	// both cannot be bound at the same time, so the old one closes at once
+	setListeners(tenant, loopback)
+	if currentListener(loopback) == nil {
+		t.Fatalf("no listener on %s", loopback)
+	}
+	if got, want := tenantListeners(tenant), []string{loopback}; !reflect.DeepEqual(got, want) {
+		t.Errorf("listeners %v, want %v", got, want)
+	}
+	conn, err := net.Dial("tcp", loopback)
+	if err != nil {
+		t.Fatalf("new listener does not accept: %v", err)
+	}
+	conn.Close()
+}
@@ -0,0 +1,81 @@
+package service
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+
+	"tcp_sandbox/domain"
+)
+
+// -----------------------------------------------------------
+// Config Migration (port-keyed tenants -> IDs and listeners)
+// -----------------------------------------------------------
+
+// tenantIDUnsafe matches what is replaced by "-" when deriving an ID from a name.
+var tenantIDUnsafe = regexp.MustCompile(`[^a-z0-9]+`)
+
+// migrateTenants upgrades tenants of older config files in place: a tenant without ID gets one
+// derived from its name, and its single Port/BindAddress becomes its listener. It returns a note
+// per tenant, empty for tenants already in the current format.
+func migrateTenants(tenants []domain.Tenant) []string {
+	taken := make(map[string]bool)
+	for i := range tenants {
+		taken[tenants[i].ID] = true
+	}
+	notes := make([]string, len(tenants))
+	for i := range tenants {
+		notes[i] = migrateTenant(&tenants[i], taken)
+	}
+	return notes
+}
+
+// MigrateTenant upgrades a tenant created by a client that still uses ports as keys, next to
+// tenants (by ID), whose IDs it must not take.
+func MigrateTenant(t *domain.Tenant, tenants map[string]*domain.Tenant) {
+	taken := make(map[string]bool, len(tenants))
+	for id := range tenants {
+		taken[id] = true
+	}
+	migrateTenant(t, taken)
+}
+
+func migrateTenant(t *domain.Tenant, taken map[string]bool) string {
+	var changes []string
+	if t.ID == "" {
+		t.ID = newTenantID(t, taken)
+		changes = append(changes, fmt.Sprintf("ID %q", t.ID))
+	}
+	if t.Port != "" && len(t.Listeners) == 0 {
+		addr := net.JoinHostPort(t.BindAddress, t.Port)
+		t.Listeners = []domain.ListenerConfig{{Address: addr}}
+		t.Port, t.BindAddress = "", ""
+		changes = append(changes, fmt.Sprintf("listener %s", addr))
+	}
+	if len(changes) == 0 {
+		return ""
+	}
+	return "older config format, migrated to " + strings.Join(changes, " and ")
+}
+
+// newTenantID derives an unused ID from the tenant's name (e.g. "Tenant A" -> "tenant-a"),
+// or from its port if it has no name.
+func newTenantID(t *domain.Tenant, taken map[string]bool) string {
+	slug := func(s string) string {
+		return strings.Trim(tenantIDUnsafe.ReplaceAllString(strings.ToLower(s), "-"), "-")
+	}
+	base := slug(t.Name)
+	if base == "" && t.Port != "" {
+		base = slug("port-" + t.Port)
+	}
+	if base == "" {
+		base = "tenant"
+	}
+	id := base
+	for n := 2; taken[id]; n++ {
+		id = fmt.Sprintf("%s-%d", base, n)
+	}
+	taken[id] = true
+	return id
+}
@@ -0,0 +1,197 @@
+package service
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"tcp_sandbox/domain"
+	"tcp_sandbox/globals"
+)
+
+func listeners(addrs ...string) []domain.ListenerConfig {
+	out := make([]domain.ListenerConfig, len(addrs))
+	for i, addr := range addrs {
+		out[i] = domain.ListenerConfig{Address: addr}
+	}
+	return out
+}
+
+func TestMigrateTenants(t *testing.T) {
+	type want struct {
+		id        string
+		listeners []domain.ListenerConfig
+		migrated  bool
+	}
+	for _, tc := range []struct {
+		name    string
+		tenants []domain.Tenant
+		want    []want
+	}{
+		{
+			name: "name slugged to ID, port to listener",
+			tenants: []domain.Tenant{
+				{TenantConfig: domain.TenantConfig{Name: "Tenant A", Port: "3000"}},
+				{TenantConfig: domain.TenantConfig{Name: " Acme / Süd GmbH ", Port: "3001", BindAddress: "127.0.0.1"}},
+			},
+			want: []want{
+				{"tenant-a", listeners(":3000"), true},
+				{"acme-s-d-gmbh", listeners("127.0.0.1:3001"), true},
+			},
+		},
+		{
+			name: "collisions get a suffix, also with IDs of later tenants",
+			tenants: []domain.Tenant{
+				{TenantConfig: domain.TenantConfig{Name: "Tenant A", Port: "3000"}},
+				{TenantConfig: domain.TenantConfig{Name: "tenant-a", Port: "3001"}},
+				{TenantConfig: domain.TenantConfig{Name: "B", Port: "3002"}},
+				{TenantConfig: domain.TenantConfig{ID: "b", Name: "Other B", Listeners: listeners(":3003")}},
+			},
+			want: []want{
+				{"tenant-a", listeners(":3000"), true},
+				{"tenant-a-2", listeners(":3001"), true},
+				{"b-2", listeners(":3002"), true},
+				{"b", listeners(":3003"), false},
+			},
+		},
+		{
+			name: "nameless tenants",
+			tenants: []domain.Tenant{
+				{TenantConfig: domain.TenantConfig{Port: "3000"}},
+				{TenantConfig: domain.TenantConfig{Name: "!!!", Listeners: listeners(":3001")}},
+				{TenantConfig: domain.TenantConfig{Listeners: listeners(":3002")}},
+			},
+			want: []want{
+				{"port-3000", listeners(":3000"), true},
+				{"tenant", listeners(":3001"), true},
+				{"tenant-2", listeners(":3002"), true},
+			},
+		},
+		{
+			name: "current format untouched",
+			tenants: []domain.Tenant{
+				{TenantConfig: domain.TenantConfig{ID: "a", Name: "A", Listeners: listeners(":3000", "127.0.0.1:3001")}},
+				// Port next to Listeners is rejected by the validator, not migrated
+				{TenantConfig: domain.TenantConfig{ID: "c", Name: "C", Port: "3002", Listeners: listeners(":3003")}},
+			},
+			want: []want{
+				{"a", listeners(":3000", "127.0.0.1:3001"), false},
+				{"c", listeners(":3003"), false},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			notes := migrateTenants(tc.tenants)
+			for i, w := range tc.want {
+				got := &tc.tenants[i]
+				if got.ID != w.id || !reflect.DeepEqual(got.Listeners, w.listeners) {
+					t.Errorf("tenants[%d]: ID %q, listeners %v; want %q, %v", i, got.ID, got.Listeners, w.id, w.listeners)
+				}
+				if w.migrated && (got.Port != "" || got.BindAddress != "") {
+					t.Errorf("tenants[%d]: Port %q / BindAddress %q kept after migration", i, got.Port, got.BindAddress)
+				}
+				if (notes[i] != "") != w.migrated {
+					t.Errorf("tenants[%d]: note %q, want migrated=%v", i, notes[i], w.migrated)
+				}
+			}
+
+			// migrating the result again changes nothing
+			again := append([]domain.Tenant(nil), tc.tenants...)
+			for i, note := range migrateTenants(again) {
+				if note != "" {
+					t.Errorf("second migration of tenants[%d]: %s", i, note)
+				}
+			}
+			if !reflect.DeepEqual(again, tc.tenants) {
+				t.Errorf("second migration changed the tenants:\n%+v\nwant:\n%+v", again, tc.tenants)
+			}
+		})
+	}
+}
+
+func TestMigrateTenantAmongExisting(t *testing.T) {
+	tenants := map[string]*domain.Tenant{
+		"tenant-a":   {TenantConfig: domain.TenantConfig{ID: "tenant-a"}},
+		"tenant-a-2": {TenantConfig: domain.TenantConfig{ID: "tenant-a-2"}},
+	}
+	pt := &domain.Tenant{TenantConfig: domain.TenantConfig{Name: "Tenant A", Port: "3000", BindAddress: "::1"}}
+	MigrateTenant(pt, tenants)
+	if pt.ID != "tenant-a-3" || !reflect.DeepEqual(pt.Listeners, listeners("[::1]:3000")) {
+		t.Errorf("got ID %q, listeners %v; want tenant-a-3, [::1]:3000", pt.ID, pt.Listeners)
+	}
+}
+
+func TestTenantByPort(t *testing.T) {
+	a := &domain.Tenant{TenantConfig: domain.TenantConfig{ID: "a", Listeners: listeners(":3000", "127.0.0.1:3001")}}
+	b := &domain.Tenant{TenantConfig: domain.TenantConfig{ID: "b", Listeners: listeners("10.0.0.1:3001", "10.0.0.1:3002")}}
+	tenants := map[string]*domain.Tenant{"a": a, "b": b}
+
+	for port, want := range map[string]*domain.Tenant{
+		"3000": a,
+		"3002": b,
+		"3001": nil, // ambiguous
+		"3003": nil,
+	} {
+		if got := TenantByPort(tenants, port); got != want {
+			t.Errorf("TenantByPort(%s) = %v, want %v", port, got, want)
+		}
+	}
+}
+
+func TestReloadPersistsMigratedTenantIDs(t *testing.T) {
+	withTenants(t)
+	t.Cleanup(func() {
+		globals.TenantsLock.Lock()
+		for _, tenant := range globals.Tenants {
+			closeTenantListeners(tenant)
+		}
+		globals.TenantsLock.Unlock()
+	})
+
+	// a free port; the validator does not accept port 0
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := strconv.Itoa(ln.Addr().(*net.TCPAddr).Port)
+	ln.Close()
+
+	path := filepath.Join(t.TempDir(), "tenants.json")
+	legacy := `[{"Name":"Old","Port":"` + port + `","BindAddress":"127.0.0.1","StartByte":2,"EndByte":3,` +
+		`"Endpoint":"http://upstream.invalid/in","AuthType":"none"}]`
+	if err := os.WriteFile(path, []byte(legacy), 0644); err != nil {
+		t.Fatal(err)
+	}
+	reloadTenantsFile(path)
+
+	globals.TenantsLock.Lock()
+	migrated := globals.Tenants["old"]
+	globals.TenantsLock.Unlock()
+	if migrated == nil {
+		t.Fatalf("legacy tenant not loaded as %q", "old")
+	}
+	data, _ := os.ReadFile(path)
+	if !strings.Contains(string(data), `"ID": "old"`) {
+		t.Fatalf("file not rewritten with the derived ID:\n%s", data)
+	}
+	if versions, _ := ListConfigVersions(path); len(versions) != 1 {
+		t.Errorf("%d versions kept, want the legacy file", len(versions))
+	}
+
+	// renaming the tenant in the rewritten file keeps its ID and runtime state
+	renamed := strings.Replace(string(data), `"Name": "Old"`, `"Name": "Renamed"`, 1)
+	if err := os.WriteFile(path, []byte(renamed), 0644); err != nil {
+		t.Fatal(err)
+	}
+	reloadTenantsFile(path)
+
+	globals.TenantsLock.Lock()
+	defer globals.TenantsLock.Unlock()
+	if len(globals.Tenants) != 1 || globals.Tenants["old"] != migrated || migrated.Name != "Renamed" {
+		t.Errorf("after the rename: tenants %v, want %q renamed in place", globals.Tenants, "old")
+	}
+}
@@ -4,10 +4,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
-	"net"
 	"os"
+	"sort"
 	"tcp_sandbox/domain"
-	"time"
 
 	"tcp_sandbox/globals"
 )
@@ -16,166 +15,164 @@ import (
 // Tenant Manager (Loading JSON, Starting/Stopping Listeners)
 // -----------------------------------------------------------
 
-func StartTenantFileManager(filename string) {
-	ticker := time.NewTicker(1 * time.Minute)
-	defer ticker.Stop()
-
-	for {
-		<-ticker.C
-		if err := LoadTenantsFromFile(filename); err != nil {
-			log.Printf("[ERROR] Could not reload tenants file: %v", err)
-			continue
-		}
-		SyncListeners()
-		if err := SaveTenantsToFile(filename); err != nil {
-			log.Printf("[ERROR] Could not save tenants file: %v", err)
-		}
-		printAllTenantsStatus()
-	}
-}
-
-// loadTenantsFromFile merges the file tenants into our global map.
+// LoadTenantsFromFile merges the file tenants into our global map. A file in an older format is
+// rewritten in the current one.
 func LoadTenantsFromFile(filename string) error {
 	data, err := os.ReadFile(filename)
 	if err != nil {
 		return err
 	}
-	var fileTenants []domain.Tenant
-	if err := json.Unmarshal(data, &fileTenants); err != nil {
-		return fmt.Errorf("json unmarshal error: %w", err)
+	migrated, err := loadTenants(data)
+	if err != nil {
+		return err
+	}
+	rememberTenantsFile(data)
+	if migrated {
+		saveMigratedTenants(filename)
+	}
+	return nil
+}
+
+// saveMigratedTenants rewrites a tenants file loaded in an older format in the current one, so
+// the IDs derived from the tenant names stick: renaming a tenant later must not change its ID.
+func saveMigratedTenants(filename string) {
+	log.Printf("[Config] Rewriting %s with tenant IDs and listeners (previous version kept)", filename)
+	if err := SaveTenantsToFile(filename); err != nil {
+		log.Printf("[ERROR] Could not rewrite migrated tenants file: %v", err)
+	}
+}
+
+// loadTenants merges the tenants of a config file's content into our global map and reports
+// whether tenants had to be migrated from an older format.
+// A config with validation errors is rejected as a whole and the running config is kept.
+func loadTenants(data []byte) (bool, error) {
+	fileTenants, migrated, err := checkTenantsConfig(data)
+	if err != nil {
+		return false, err
 	}
 
 	globals.TenantsLock.Lock()
 	defer globals.TenantsLock.Unlock()
+	mergeTenants(fileTenants)
+	return migrated, nil
+}
 
-	filePorts := make(map[string]bool)
+// checkTenantsConfig parses and validates a config file's content, logging its warnings.
+func checkTenantsConfig(data []byte) ([]domain.Tenant, bool, error) {
+	fileTenants, report, migrated := parseTenantsConfig(data)
+	for _, w := range report.Warnings {
+		log.Printf("[WARN] Tenants config: %s", FormatIssue(w))
+	}
+	if !report.Valid {
+		return nil, false, &ConfigError{Report: report}
+	}
+	return fileTenants, migrated > 0, nil
+}
 
+// mergeTenants updates our global map to the validated file tenants; tenants missing from the
+// file are marked for removal (see SyncListeners). Caller holds globals.TenantsLock.
+func mergeTenants(fileTenants []domain.Tenant) {
+	fileIDs := make(map[string]bool)
 	for i := range fileTenants {
 		ft := &fileTenants[i]
-		port := ft.Port
-		filePorts[port] = true
+		fileIDs[ft.ID] = true
 
-		existing, ok := globals.Tenants[port]
+		existing, ok := globals.Tenants[ft.ID]
 		if !ok {
 			// new tenant
-			globals.Tenants[port] = ft
+			globals.Tenants[ft.ID] = ft
 		} else {
-			// update existing's config, keep counters & tokens
-			existing.Name = ft.Name
-			existing.Comment = ft.Comment
-			existing.StartByte = ft.StartByte
-			existing.EndByte = ft.EndByte
-			existing.SimpleAuthToken = ft.SimpleAuthToken
-			existing.OAuthCredentials.ClientID = ft.OAuthCredentials.ClientID
-			existing.OAuthCredentials.ClientSecret = ft.OAuthCredentials.ClientSecret
-			existing.OAuthCredentials.TokenURL = ft.OAuthCredentials.TokenURL
-			existing.OAuthCredentials.Scopes = ft.OAuthCredentials.Scopes
-
-			// Keep-alive fields
-			existing.KeepAliveIntervalSec = ft.KeepAliveIntervalSec
-			existing.KeepAliveFile = ft.KeepAliveFile
-			// Keep auth fields
-			existing.Endpoint = ft.Endpoint
+			// update existing's config, keep its runtime state (counters, connections)
+			existing.TenantConfig = ft.TenantConfig
+			existing.SecretRefs = ft.SecretRefs
 		}
 	}
 
 	// Mark removals
-	for port := range globals.Tenants {
-		if !filePorts[port] {
+	for id := range globals.Tenants {
+		if !fileIDs[id] {
 			// Mark removed
-			globals.Tenants[port].Name = ""
+			globals.Tenants[id].Name = ""
 		}
 	}
+}
 
-	return nil
+// CloneTenants returns copies of the configs of all tenants by ID, to plan a change on before
+// applying it. Caller holds globals.TenantsLock.
+func CloneTenants() map[string]*domain.Tenant {
+	clones := make(map[string]*domain.Tenant, len(globals.Tenants))
+	for id, t := range globals.Tenants {
+		clones[id] = cloneTenant(t)
+	}
+	return clones
 }
 
+// SyncListeners starts/stops listeners and keep-alives to match globals.Tenants.
+// Caller holds globals.TenantsLock.
 func SyncListeners() {
-	for port, t := range globals.Tenants {
+	for id, t := range globals.Tenants {
 		if t.Name == "" {
 			// removed tenant
-			StopTenantListener(port)
-			delete(globals.Tenants, port)
-			continue
-		}
-		if _, ok := globals.Listeners[port]; !ok {
-			if err := startTenantListener(port, t); err != nil {
-				log.Printf("[ERROR] Failed to start listener for tenant %q on port %s: %v", t.Name, port, err)
-			}
+			StopTenantListeners(t)
+			delete(globals.Tenants, id)
 		}
 	}
-	for port, ln := range globals.Listeners {
-		if _, ok := globals.Tenants[port]; !ok {
-			// tenant no longer in memory
-			log.Printf("Stopping listener on port %s (removed tenant)", port)
-			_ = ln.Close()
-			delete(globals.Listeners, port)
-		}
+	for _, t := range globals.Tenants {
+		updateConfigGeneration(t)
+		syncTenantListeners(t)
+		syncKeepAlive(t)
 	}
+	stopRemovedKeepAlives()
+	forgetRemovedGenerations()
+	stopOrphanedListeners()
+	configureEndpointLimiters()
 }
 
 func SaveTenantsToFile(filename string) error {
 	globals.TenantsLock.Lock()
 	defer globals.TenantsLock.Unlock()
 
-	var out []domain.Tenant
-	for _, t := range globals.Tenants {
-		out = append(out, *t)
+	key, err := LoadMasterKey()
+	if err != nil {
+		return err
+	}
+
+	// Secrets loaded from references are written back as references.
+	// With a master key configured, plain secrets are written encrypted.
+	out := make([]*domain.Tenant, 0, len(globals.Tenants))
+	for _, t := range sortedTenants() {
+		if key != nil {
+			if err := encryptPlainSecrets(t, key); err != nil {
+				return err
+			}
+		}
+		out = append(out, persistableTenant(t))
 	}
 	data, err := json.MarshalIndent(out, "", "  ")
 	if err != nil {
 		return fmt.Errorf("json marshal error: %w", err)
 	}
-	return os.WriteFile(filename, data, 0644)
+	return writeTenantsFile(filename, data)
 }
 
 func StartAllTenants() {
 	globals.TenantsLock.Lock()
 	defer globals.TenantsLock.Unlock()
 
-	for port, t := range globals.Tenants {
-		if _, ok := globals.Listeners[port]; !ok {
-			if err := startTenantListener(port, t); err != nil {
-				log.Printf("[ERROR] Failed to start listener for tenant %q on port %s: %v", t.Name, port, err)
-			}
-		}
-	}
-}
-
-// startTenantListener begins listening on a port; also starts keep-alive if needed.
-func startTenantListener(port string, t *domain.Tenant) error {
-	ln, err := net.Listen("tcp", ":"+port)
-	if err != nil {
-		return err
+	for _, t := range globals.Tenants {
+		updateConfigGeneration(t)
+		syncTenantListeners(t)
+		syncKeepAlive(t)
 	}
-	globals.Listeners[port] = ln
-
-	log.Printf("Listening for tenant %q on port %s", t.Name, port)
-
-	// Start keep-alive routine if configured
-	StartKeepAliveRoutine(t)
-
-	go func() {
-		for {
-			conn, err := ln.Accept()
-			if err != nil {
-				log.Printf("Listener stopped on port %s (tenant %q). Err: %v", port, t.Name, err)
-				return
-			}
-			addConnection(t, conn)
-			log.Printf("Accepted connection from %s for tenant %q (port %s)", conn.RemoteAddr(), t.Name, port)
-			go handleConnection(conn, t)
-		}
-	}()
-	return nil
+	configureEndpointLimiters()
 }
 
-func StopTenantListener(port string) {
-	ln, ok := globals.Listeners[port]
-	if !ok {
-		return
+// sortedTenants returns the tenants ordered by ID. Caller holds globals.TenantsLock.
+func sortedTenants() []*domain.Tenant {
+	out := make([]*domain.Tenant, 0, len(globals.Tenants))
+	for _, t := range globals.Tenants {
+		out = append(out, t)
 	}
-	log.Printf("Stopping listener on port %s", port)
-	_ = ln.Close()
-	delete(globals.Listeners, port)
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
 }
@@ -0,0 +1,122 @@
+package service
+
+import (
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// -----------------------------------------------------------
+// Throttled Message Queue (per upstream endpoint)
+// -----------------------------------------------------------
+
+// throttleQueueSize bounds the messages queued per endpoint while its upstream throttles us.
+const throttleQueueSize = 1000
+
+// deliverMessage sends a message upstream. While its endpoint is paused or messages throttled
+// earlier are still queued, the message joins the queue instead, so queued messages keep their order.
+func deliverMessage(m *upstreamMessage) {
+	l := getEndpointLimiter(m.cfg.Endpoint)
+
+	l.mu.Lock()
+	busy := len(l.queue) > 0 || time.Now().Before(l.pausedUntil)
+	if busy {
+		l.enqueueLocked(m)
+	}
+	l.mu.Unlock()
+	if busy {
+		return
+	}
+
+	if sendMessage(l, m) {
+		l.mu.Lock()
+		l.enqueueLocked(m)
+		l.mu.Unlock()
+	}
+}
+
+// enqueueLocked queues a throttled message and starts flushing the queue if nobody does.
+// A full queue drops the message. Caller holds l.mu.
+func (l *endpointLimiter) enqueueLocked(m *upstreamMessage) {
+	if len(l.queue) >= throttleQueueSize {
+		atomic.AddUint64(&m.t.ThrottleDropped, 1)
+		log.Printf("[WARN][Tenant %q] Throttle queue of %s full (%d messages), dropping message",
+			m.cfg.TenantName, m.cfg.Endpoint, len(l.queue))
+		return
+	}
+	l.queue = append(l.queue, m)
+	atomic.AddInt64(&m.t.Queued, 1)
+	if !l.flushing {
+		l.flushing = true
+		go l.flush()
+	}
+}
+
+// flush sends the queued messages in order once the endpoint's pause ends. A message throttled
+// again stays at the head of the queue until the next pause ends.
+func (l *endpointLimiter) flush() {
+	for {
+		l.mu.Lock()
+		if len(l.queue) == 0 {
+			l.flushing = false
+			l.mu.Unlock()
+			return
+		}
+		m := l.queue[0]
+		l.mu.Unlock()
+
+		if sendMessage(l, m) {
+			continue
+		}
+
+		l.mu.Lock()
+		if len(l.queue) > 0 && l.queue[0] == m { // not dropped by drainThrottleQueues meanwhile
+			l.queue[0] = nil
+			l.queue = l.queue[1:]
+			atomic.AddInt64(&m.t.Queued, -1)
+		}
+		l.mu.Unlock()
+	}
+}
+
+// queuedMessages counts the throttled messages of all endpoints.
+func queuedMessages() int {
+	endpointLimitersLock.Lock()
+	defer endpointLimitersLock.Unlock()
+
+	n := 0
+	for _, l := range endpointLimiters {
+		l.mu.Lock()
+		n += len(l.queue)
+		l.mu.Unlock()
+	}
+	return n
+}
+
+// drainThrottleQueues gives queued messages until the deadline to be delivered and drops the
+// rest. The queues live in memory only, so whatever is left would be lost on exit anyway.
+func drainThrottleQueues(deadline time.Time) {
+	n := queuedMessages()
+	if n == 0 {
+		return
+	}
+	log.Printf("Waiting for %d throttled message(s), deadline %s", n, deadline.Format(time.RFC3339))
+	if waitUntil(deadline, nil, func() bool { return queuedMessages() == 0 }) {
+		return
+	}
+
+	endpointLimitersLock.Lock()
+	defer endpointLimitersLock.Unlock()
+	for endpoint, l := range endpointLimiters {
+		l.mu.Lock()
+		if len(l.queue) > 0 {
+			log.Printf("[WARN] Dropping %d throttled message(s) for %s at the shutdown deadline", len(l.queue), endpoint)
+		}
+		for _, m := range l.queue {
+			atomic.AddUint64(&m.t.ThrottleDropped, 1)
+			atomic.AddInt64(&m.t.Queued, -1)
+		}
+		l.queue = nil
+		l.mu.Unlock()
+	}
+}
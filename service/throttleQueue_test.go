@@ -0,0 +1,104 @@
+package service
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"tcp_sandbox/domain"
+)
+
+// newThrottleTestTenant returns a tenant posting text messages to endpoint.
+func newThrottleTestTenant(t *testing.T, endpoint string) *domain.Tenant {
+	return &domain.Tenant{TenantConfig: domain.TenantConfig{
+		ID: t.Name(), Name: t.Name(), Endpoint: endpoint, MessageFormat: "text", AuthType: "none",
+	}}
+}
+
+func TestThrottledMessagesAreQueuedAndFlushedInOrder(t *testing.T) {
+	var mu sync.Mutex
+	var received []string
+	throttled := false
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		if !throttled {
+			throttled = true
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		received = append(received, string(body))
+	}))
+	defer endpoint.Close()
+
+	tenant := newThrottleTestTenant(t, endpoint.URL)
+	for _, msg := range []string{"m1", "m2", "m3"} {
+		atomic.AddInt64(&tenant.InFlight, 1)
+		handleCompleteMessage(tenant, upstreamConfigOf(tenant), msg)
+	}
+
+	// queued messages do not hold InFlight, so a drain is not blocked by them
+	if got := atomic.LoadInt64(&tenant.InFlight); got != 0 {
+		t.Errorf("InFlight = %d while queued, want 0", got)
+	}
+	if got := atomic.LoadInt64(&tenant.Queued); got != 3 {
+		t.Errorf("Queued = %d, want 3", got)
+	}
+
+	deadline := time.Now().Add(3 * time.Second)
+	for atomic.LoadInt64(&tenant.Queued) > 0 && time.Now().Before(deadline) {
+		time.Sleep(20 * time.Millisecond)
+	}
+	mu.Lock()
+	got := append([]string(nil), received...)
+	mu.Unlock()
+	if len(got) != 3 || got[0] != "m1" || got[1] != "m2" || got[2] != "m3" {
+		t.Errorf("endpoint received %q after the pause, want [m1 m2 m3]", got)
+	}
+	if tenant.Throttled != 1 || tenant.UpstreamErrors != 0 || tenant.ThrottleDropped != 0 {
+		t.Errorf("Throttled/UpstreamErrors/ThrottleDropped = %d/%d/%d, want 1/0/0",
+			tenant.Throttled, tenant.UpstreamErrors, tenant.ThrottleDropped)
+	}
+}
+
+func TestThrottleQueueIsBoundedAndDroppedAtShutdown(t *testing.T) {
+	var calls int32
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.Header().Set("Retry-After", "60")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer endpoint.Close()
+
+	tenant := newThrottleTestTenant(t, endpoint.URL)
+	cfg := upstreamConfigOf(tenant)
+	for i := 0; i <= throttleQueueSize; i++ {
+		deliverMessage(&upstreamMessage{t: tenant, cfg: cfg, body: []byte("msg"), contentType: "text/plain"})
+	}
+
+	// only the first message reached the endpoint; the others queued behind the pause
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("endpoint called %d times, want 1", n)
+	}
+	if got := atomic.LoadInt64(&tenant.Queued); got != throttleQueueSize {
+		t.Errorf("Queued = %d, want %d", got, throttleQueueSize)
+	}
+	if tenant.ThrottleDropped != 1 {
+		t.Errorf("ThrottleDropped = %d with a full queue, want 1", tenant.ThrottleDropped)
+	}
+
+	drainThrottleQueues(time.Now())
+	if got := atomic.LoadInt64(&tenant.Queued); got != 0 {
+		t.Errorf("Queued = %d after the shutdown deadline, want 0", got)
+	}
+	if tenant.ThrottleDropped != throttleQueueSize+1 || tenant.UpstreamErrors != 0 {
+		t.Errorf("ThrottleDropped/UpstreamErrors = %d/%d, want %d/0",
+			tenant.ThrottleDropped, tenant.UpstreamErrors, throttleQueueSize+1)
+	}
+}
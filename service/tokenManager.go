@@ -0,0 +1,374 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"tcp_sandbox/domain"
+	"tcp_sandbox/globals"
+)
+
+// -----------------------------------------------------------
+// OAuth Token Manager (shared cache, deduplicated refresh)
+// -----------------------------------------------------------
+
+const (
+	tokenMinValidity     = 10 * time.Second // a token is only handed out if valid for at least this long
+	tokenRefreshAhead    = 60 * time.Second // proactive refresh window before expiry
+	tokenManagerInterval = 5 * time.Second  // how often the background refresher looks at the cache
+	tokenIdleEvict       = 1 * time.Hour    // drop cached tokens nobody asked for in this long
+)
+
+// oauthToken is one access token as returned by a token endpoint.
+type oauthToken struct {
+	AccessToken  string
+	TokenType    string
+	Expiry       time.Time
+	RefreshToken string // only set if the token endpoint issued one
+}
+
+// refreshCall is an in-flight token request; concurrent callers wait on done.
+type refreshCall struct {
+	done  chan struct{}
+	token oauthToken
+	err   error
+}
+
+// cachedToken is shared by every tenant with the same client ID, token URL and scopes.
+type cachedToken struct {
+	mu          sync.Mutex
+	creds       domain.OAuthCredentials // latest credentials seen for this key
+	token       oauthToken
+	lastUsed    time.Time
+	lastRefresh time.Time
+	lastError   string
+	inFlight    *refreshCall
+
+	refreshToken string // latest refresh token issued for this key, tried before the configured grant
+}
+
+var (
+	tokenCache     = make(map[string]*cachedToken)
+	tokenCacheLock sync.Mutex
+)
+
+// tokenCacheKey identifies tokens that can be shared between tenants.
+// Besides client ID, token URL and scopes, everything that changes who the token is issued for is part of the key.
+func tokenCacheKey(c domain.OAuthCredentials) string {
+	scopes := append([]string(nil), c.Scopes...)
+	sort.Strings(scopes)
+	return strings.Join([]string{
+		c.ClientID, c.TokenURL, strings.Join(scopes, " "),
+		c.GrantType, c.Username, c.Audience, c.Resource,
+	}, "|")
+}
+
+func getCachedToken(c domain.OAuthCredentials) *cachedToken {
+	key := tokenCacheKey(c)
+
+	tokenCacheLock.Lock()
+	defer tokenCacheLock.Unlock()
+
+	e, ok := tokenCache[key]
+	if !ok {
+		e = &cachedToken{creds: c}
+		tokenCache[key] = e
+	}
+	return e
+}
+
+// getOrRefreshToken returns a token valid for at least tokenMinValidity, fetching one if needed.
+// Concurrent callers for the same credentials share a single token request.
+func getOrRefreshToken(cfg upstreamConfig) (oauthToken, error) {
+	e := getCachedToken(cfg.OAuthCredentials)
+
+	e.mu.Lock()
+	e.creds = cfg.OAuthCredentials
+	e.lastUsed = time.Now()
+	if e.token.Expiry.After(time.Now().Add(tokenMinValidity)) {
+		tok := e.token
+		e.mu.Unlock()
+		return tok, nil
+	}
+	e.mu.Unlock()
+
+	tok, err := e.refresh()
+	if err != nil {
+		return oauthToken{}, err
+	}
+	log.Printf("[Tenant %q] Using OAuth token (%s) expiring at %v", cfg.TenantName, tok.TokenType, tok.Expiry)
+	return tok, nil
+}
+
+// refresh requests a new token, or joins a request that is already running.
+func (e *cachedToken) refresh() (oauthToken, error) {
+	e.mu.Lock()
+	if call := e.inFlight; call != nil {
+		e.mu.Unlock()
+		<-call.done
+		return call.token, call.err
+	}
+	call := &refreshCall{done: make(chan struct{})}
+	e.inFlight = call
+	creds := e.creds
+	refreshToken := e.refreshToken
+	e.mu.Unlock()
+
+	call.token, call.err = requestToken(creds, refreshToken)
+	if call.err != nil && refreshToken != "" {
+		// Refresh token expired or revoked, start over with the configured grant
+		log.Printf("[WARN] OAuth refresh_token grant for client %q failed (%v); falling back to %s", creds.ClientID, call.err, grantType(creds))
+		refreshToken = ""
+		call.token, call.err = requestToken(creds, "")
+	}
+
+	e.mu.Lock()
+	e.inFlight = nil
+	e.lastRefresh = time.Now()
+	if call.err != nil {
+		e.lastError = call.err.Error()
+		e.refreshToken = refreshToken
+	} else {
+		e.token = call.token
+		e.lastError = ""
+		if call.token.RefreshToken != "" {
+			e.refreshToken = call.token.RefreshToken
+		}
+	}
+	e.mu.Unlock()
+	close(call.done)
+
+	if call.err == nil {
+		log.Printf("New OAuth token for client %q (%s) expires at %v", creds.ClientID, call.token.TokenType, call.token.Expiry)
+	}
+	return call.token, call.err
+}
+
+// invalidateToken drops a cached token the upstream rejected, so the next caller fetches a new one.
+// It is a no-op if the cache already holds a different (newer) token.
+func invalidateToken(c domain.OAuthCredentials, rejected oauthToken) {
+	e := getCachedToken(c)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.token.AccessToken == rejected.AccessToken {
+		e.token = oauthToken{}
+		e.lastError = "token rejected by upstream"
+	}
+}
+
+// grantType returns the configured grant, defaulting to client_credentials.
+func grantType(c domain.OAuthCredentials) string {
+	if c.GrantType == "" {
+		return "client_credentials"
+	}
+	return strings.ToLower(c.GrantType)
+}
+
+// requestToken asks the token endpoint for a new token. A non-empty refreshToken
+// takes precedence over the configured grant type.
+func requestToken(c domain.OAuthCredentials, refreshToken string) (oauthToken, error) {
+	now := time.Now()
+
+	data := url.Values{}
+	switch grant := grantType(c); {
+	case refreshToken != "":
+		data.Set("grant_type", "refresh_token")
+		data.Set("refresh_token", refreshToken)
+	case grant == "client_credentials":
+		data.Set("grant_type", "client_credentials")
+	case grant == "password":
+		data.Set("grant_type", "password")
+		data.Set("username", c.Username)
+		data.Set("password", c.Password)
+	case grant == "refresh_token":
+		if c.RefreshToken == "" {
+			return oauthToken{}, fmt.Errorf("refresh_token grant requires RefreshToken")
+		}
+		data.Set("grant_type", "refresh_token")
+		data.Set("refresh_token", c.RefreshToken)
+	default:
+		return oauthToken{}, fmt.Errorf("unsupported OAuth grant type %q", c.GrantType)
+	}
+	if len(c.Scopes) > 0 {
+		data.Set("scope", strings.Join(c.Scopes, " "))
+	}
+	if c.Audience != "" {
+		data.Set("audience", c.Audience)
+	}
+	if c.Resource != "" {
+		data.Set("resource", c.Resource)
+	}
+
+	// Client authentication
+	useBasicAuth := false
+	switch strings.ToLower(c.AuthMethod) {
+	case "", "client_secret_post":
+		data.Set("client_id", c.ClientID)
+		data.Set("client_secret", c.ClientSecret)
+	case "client_secret_basic":
+		useBasicAuth = true
+	case "private_key_jwt":
+		assertion, err := buildClientAssertion(c)
+		if err != nil {
+			return oauthToken{}, fmt.Errorf("building client assertion: %w", err)
+		}
+		data.Set("client_id", c.ClientID)
+		data.Set("client_assertion_type", clientAssertionType)
+		data.Set("client_assertion", assertion)
+	case "none":
+		data.Set("client_id", c.ClientID)
+	default:
+		return oauthToken{}, fmt.Errorf("unsupported OAuth client auth method %q", c.AuthMethod)
+	}
+
+	req, err := http.NewRequest("POST", c.TokenURL, strings.NewReader(data.Encode()))
+	if err != nil {
+		return oauthToken{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if useBasicAuth {
+		// RFC 6749 2.3.1: id and secret are form-encoded before being put into the Basic header
+		req.SetBasicAuth(url.QueryEscape(c.ClientID), url.QueryEscape(c.ClientSecret))
+	}
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return oauthToken{}, fmt.Errorf("error requesting new token: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return oauthToken{}, fmt.Errorf("token endpoint returned status %d", resp.StatusCode)
+	}
+
+	var tokenResp struct {
+		AccessToken  string `json:"access_token"`
+		ExpiresIn    int64  `json:"expires_in"` // in seconds
+		TokenType    string `json:"token_type"`
+		RefreshToken string `json:"refresh_token"`
+	}
+	dec := json.NewDecoder(resp.Body)
+	if err := dec.Decode(&tokenResp); err != nil {
+		return oauthToken{}, fmt.Errorf("invalid token response: %w", err)
+	}
+
+	tok := oauthToken{
+		AccessToken:  tokenResp.AccessToken,
+		TokenType:    tokenResp.TokenType,
+		RefreshToken: tokenResp.RefreshToken,
+	}
+	if tok.TokenType == "" {
+		tok.TokenType = "Bearer"
+	}
+	if tokenResp.ExpiresIn > 0 {
+		tok.Expiry = now.Add(time.Second * time.Duration(tokenResp.ExpiresIn))
+	} else {
+		tok.Expiry = now.Add(1 * time.Hour)
+	}
+	return tok, nil
+}
+
+// StartTokenManager refreshes tokens in use shortly before they expire, so message
+// delivery never has to wait for the token endpoint. It also evicts idle tokens.
+func StartTokenManager() {
+	ticker := time.NewTicker(tokenManagerInterval)
+	defer ticker.Stop()
+
+	for {
+		<-ticker.C
+		now := time.Now()
+
+		tokenCacheLock.Lock()
+		var due []*cachedToken
+		for key, e := range tokenCache {
+			e.mu.Lock()
+			idle := now.Sub(e.lastUsed) > tokenIdleEvict
+			expiring := e.token.AccessToken != "" && e.token.Expiry.Before(now.Add(tokenRefreshAhead))
+			busy := e.inFlight != nil
+			e.mu.Unlock()
+
+			if idle && !busy {
+				delete(tokenCache, key)
+				continue
+			}
+			if expiring && !busy {
+				due = append(due, e)
+			}
+		}
+		tokenCacheLock.Unlock()
+
+		for _, e := range due {
+			go func(e *cachedToken) {
+				if _, err := e.refresh(); err != nil {
+					log.Printf("[WARN] Proactive OAuth token refresh failed: %v", err)
+				}
+			}(e)
+		}
+	}
+}
+
+// TokenStatuses returns a redacted snapshot of all cached tokens.
+func TokenStatuses() []domain.TokenStatus {
+	// Which tenants use which cache entry
+	users := make(map[string][]string)
+	globals.TenantsLock.Lock()
+	for _, t := range globals.Tenants {
+		if t.OAuthCredentials.TokenURL == "" {
+			continue
+		}
+		key := tokenCacheKey(t.OAuthCredentials)
+		users[key] = append(users[key], t.Name)
+	}
+	globals.TenantsLock.Unlock()
+
+	tokenCacheLock.Lock()
+	defer tokenCacheLock.Unlock()
+
+	var out []domain.TokenStatus
+	for key, e := range tokenCache {
+		e.mu.Lock()
+		st := domain.TokenStatus{
+			ClientID:    e.creds.ClientID,
+			TokenURL:    e.creds.TokenURL,
+			Scopes:      e.creds.Scopes,
+			Tenants:     users[key],
+			Token:       redactToken(e.token.AccessToken),
+			TokenType:   e.token.TokenType,
+			TokenExpiry: e.token.Expiry,
+			LastRefresh: e.lastRefresh,
+			LastUsed:    e.lastUsed,
+			Refreshing:  e.inFlight != nil,
+			LastError:   e.lastError,
+		}
+		e.mu.Unlock()
+		sort.Strings(st.Tenants)
+		out = append(out, st)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].TokenURL != out[j].TokenURL {
+			return out[i].TokenURL < out[j].TokenURL
+		}
+		return out[i].ClientID < out[j].ClientID
+	})
+	return out
+}
+
+// redactToken keeps just enough of a token to tell two tokens apart.
+func redactToken(token string) string {
+	if token == "" {
+		return ""
+	}
+	if len(token) <= 8 {
+		return fmt.Sprintf("…(%d chars)", len(token))
+	}
+	return fmt.Sprintf("%s…(%d chars)", token[:4], len(token))
+}
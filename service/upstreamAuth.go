@@ -0,0 +1,69 @@
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"tcp_sandbox/domain"
+)
+
+// -----------------------------------------------------------
+// Upstream Authentication
+// -----------------------------------------------------------
+
+// upstreamAuthType resolves the tenant's effective auth type, including the legacy default.
+func upstreamAuthType(t *domain.Tenant) string {
+	if t.AuthType != "" {
+		return strings.ToLower(t.AuthType)
+	}
+	if t.SimpleAuthToken != "" {
+		return "simple"
+	}
+	return "oauth"
+}
+
+// applyUpstreamAuth adds the tenant's extra headers and credentials to an upstream request.
+// If an OAuth token was used it is returned, so the caller can invalidate it when the upstream rejects it.
+func applyUpstreamAuth(req *http.Request, cfg upstreamConfig) (*oauthToken, error) {
+	for name, value := range cfg.ExtraHeaders {
+		req.Header.Set(name, value)
+	}
+
+	switch cfg.AuthType {
+	case "none":
+	case "simple":
+		req.Header.Set("X-Auth", cfg.SimpleAuthToken)
+	case "oauth":
+		tok, err := getOrRefreshToken(cfg)
+		if err != nil {
+			return nil, &authError{fmt.Errorf("unable to get token for tenant %q: %w", cfg.TenantName, err)}
+		}
+		req.Header.Set("Authorization", fmt.Sprintf("%s %s", tok.TokenType, tok.AccessToken))
+		return &tok, nil
+	case "basic":
+		if cfg.BasicAuth == nil {
+			return nil, &authError{fmt.Errorf("auth type basic requires BasicAuth")}
+		}
+		req.SetBasicAuth(cfg.BasicAuth.Username, cfg.BasicAuth.Password)
+	case "apikey":
+		if cfg.APIKey == nil || cfg.APIKey.Name == "" {
+			return nil, &authError{fmt.Errorf("auth type apikey requires APIKey.Name")}
+		}
+		switch strings.ToLower(cfg.APIKey.In) {
+		case "", "header":
+			req.Header.Set(cfg.APIKey.Name, cfg.APIKey.Value)
+		case "query":
+			q := req.URL.Query()
+			q.Set(cfg.APIKey.Name, cfg.APIKey.Value)
+			req.URL.RawQuery = q.Encode()
+		default:
+			return nil, &authError{fmt.Errorf("unknown APIKey.In %q (want header or query)", cfg.APIKey.In)}
+		}
+	case "bearer":
+		req.Header.Set("Authorization", "Bearer "+cfg.BearerToken)
+	default:
+		return nil, &authError{fmt.Errorf("unknown auth type %q", cfg.AuthType)}
+	}
+	return nil, nil
+}
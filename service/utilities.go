@@ -2,27 +2,41 @@ package service
 
 import (
 	"log"
-	"net"
+	"strings"
 	"sync/atomic"
 	"tcp_sandbox/domain"
 	"tcp_sandbox/globals"
+	"time"
 )
 
 // -----------------------------------------------------------
 // Utility & Logging
 // -----------------------------------------------------------
 
-func addConnection(t *domain.Tenant, conn net.Conn) {
+func addConnection(t *domain.Tenant, conn *domain.Connection) {
 	t.ConnectionsLock.Lock()
 	defer t.ConnectionsLock.Unlock()
 	t.Connections = append(t.Connections, conn)
+	if !t.DrainDeadline.IsZero() {
+		// accepted just before the listener closed
+		go drainConnection(t, conn, t.DrainGoodbye, t.DrainDeadline)
+	}
+}
+
+// closeConnection closes a connection and forgets it; later calls do nothing.
+func closeConnection(t *domain.Tenant, c *domain.Connection) {
+	c.CloseOnce.Do(func() {
+		removeConnection(t, c)
+		c.Conn.Close()
+		close(c.Closed)
+	})
 }
 
-func removeConnection(t *domain.Tenant, conn net.Conn) {
+func removeConnection(t *domain.Tenant, conn *domain.Connection) {
 	t.ConnectionsLock.Lock()
 	defer t.ConnectionsLock.Unlock()
 
-	var updated []net.Conn
+	var updated []*domain.Connection
 	for _, c := range t.Connections {
 		if c != conn {
 			updated = append(updated, c)
@@ -31,9 +45,32 @@ func removeConnection(t *domain.Tenant, conn net.Conn) {
 	t.Connections = updated
 }
 
+// touchConnection records traffic in either direction, postponing idle keep-alives.
+func touchConnection(c *domain.Connection) {
+	atomic.StoreInt64(&c.LastActivity, time.Now().UnixNano())
+}
+
 func logError(t *domain.Tenant, err error) {
+	logErrorFor(t, t.Name, err)
+}
+
+// logErrorFor is logError for code working on a config snapshot, which logs the snapshot's
+// tenant name instead of reading t.Name without globals.TenantsLock.
+func logErrorFor(t *domain.Tenant, name string, err error) {
 	atomic.AddUint64(&t.Errors, 1)
-	log.Printf("[ERROR][Tenant %q] %v", t.Name, err)
+	log.Printf("[ERROR][Tenant %q] %v", name, err)
+}
+
+// logAuthFailure counts errors caused by missing or rejected upstream credentials.
+func logAuthFailure(t *domain.Tenant, name string, err error) {
+	atomic.AddUint64(&t.AuthFailures, 1)
+	logErrorFor(t, name, err)
+}
+
+// logUpstreamError counts any other failed upstream call (network errors, non-2xx answers).
+func logUpstreamError(t *domain.Tenant, name string, err error) {
+	atomic.AddUint64(&t.UpstreamErrors, 1)
+	logErrorFor(t, name, err)
 }
 
 // printAllTenantsStatus logs a status overview of all tenants each time it’s called.
@@ -42,30 +79,109 @@ func printAllTenantsStatus() {
 	defer globals.TenantsLock.Unlock()
 
 	log.Println("======= Tenant Status =======")
-	for _, t := range globals.Tenants {
+	for _, t := range sortedTenants() {
 		printTenantStatus(t)
 	}
 	log.Println("=============================")
 }
 
 func printTenantStatus(t *domain.Tenant) {
-	received := atomic.LoadUint64(&t.BytesReceived)
-	sent := atomic.LoadUint64(&t.BytesSent)
-	errs := atomic.LoadUint64(&t.Errors)
+	st := tenantStatus(t)
 
-	t.ConnectionsLock.Lock()
-	connCount := len(t.Connections)
-	t.ConnectionsLock.Unlock()
+	nextFire := "-"
+	if st.KeepAliveNextFire != nil {
+		nextFire = st.KeepAliveNextFire.Format(time.RFC3339)
+	}
 
-	log.Printf("[Status][Tenant %q on port %s]\n"+
+	log.Printf("[Status][Tenant %q (%s) on %s]\n"+
 		"  - Connections: %d\n"+
-		"  - BytesReceived: %d | BytesSent: %d | Errors: %d\n"+
-		"  - KeepAlive: Interval=%ds File=%s\n"+
+		"  - BytesReceived: %d | BytesSent: %d | Errors: %d | Throttled: %d\n"+
+		"  - Messages: %d | Heartbeats: %d\n"+
+		"  - Upstream: AuthFailures=%d UpstreamErrors=%d Queued=%d ThrottleDropped=%d\n"+
+		"  - KeepAlive: Interval=%ds File=%s Next=%s\n"+
 		"  - Comment: %s\n",
-		t.Name, t.Port,
-		connCount,
-		received, sent, errs,
-		t.KeepAliveIntervalSec, t.KeepAliveFile,
-		t.Comment,
+		st.Name, st.ID, strings.Join(st.ListenAddresses, ", "),
+		st.Connections,
+		st.BytesReceived, st.BytesSent, st.Errors, st.Throttled,
+		st.Messages, st.Heartbeats,
+		st.AuthFailures, st.UpstreamErrors, st.Queued, st.ThrottleDropped,
+		st.KeepAliveIntervalSec, st.KeepAliveFile, nextFire,
+		st.Comment,
 	)
 }
+
+// TenantStatuses returns a status snapshot of all tenants, ordered by ID.
+func TenantStatuses() []domain.TenantStatus {
+	globals.TenantsLock.Lock()
+	defer globals.TenantsLock.Unlock()
+
+	out := make([]domain.TenantStatus, 0, len(globals.Tenants))
+	for _, t := range sortedTenants() {
+		out = append(out, tenantStatus(t))
+	}
+	return out
+}
+
+// tenantStatus collects the counters and keep-alive state of one tenant.
+// Caller holds globals.TenantsLock.
+func tenantStatus(t *domain.Tenant) domain.TenantStatus {
+	t.ConnectionsLock.Lock()
+	details := make([]domain.ConnectionStatus, 0, len(t.Connections))
+	for _, c := range t.Connections {
+		cs := domain.ConnectionStatus{
+			RemoteAddr:       c.Conn.RemoteAddr().String(),
+			Endpoint:         c.Endpoint,
+			ConfigGeneration: connFraming(c).Generation,
+			ConnectedAt:      c.ConnectedAt,
+			LastActivity:     time.Unix(0, atomic.LoadInt64(&c.LastActivity)),
+			OutboundQueue:    len(c.Outbound),
+		}
+		if next := atomic.LoadInt64(&c.NextKeepAlive); next != 0 {
+			at := time.Unix(0, next)
+			cs.NextKeepAlive = &at
+		}
+		if reply := atomic.LoadInt64(&c.LastKeepAliveReply); reply != 0 {
+			at := time.Unix(0, reply)
+			cs.LastKeepAliveReply = &at
+		}
+		cs.MissedKeepAlives = atomic.LoadUint64(&c.MissedKeepAlives)
+		cs.MissedKeepAlivesTotal = atomic.LoadUint64(&c.MissedKeepAlivesTotal)
+		if hb := atomic.LoadInt64(&c.LastHeartbeat); hb != 0 {
+			at := time.Unix(0, hb)
+			cs.LastHeartbeat = &at
+		}
+		details = append(details, cs)
+	}
+	t.ConnectionsLock.Unlock()
+
+	counters := loadCounters(t)
+	st := domain.TenantStatus{
+		ID:                t.ID,
+		Name:              t.Name,
+		Comment:           t.Comment,
+		Connections:       len(details),
+		ConnectionDetails: details,
+		ConfigGeneration:  loadTenantFraming(t).Generation,
+		ListenAddresses:   listenAddresses(t),
+
+		BytesReceived:  counters.BytesReceived,
+		BytesSent:      counters.BytesSent,
+		Errors:         counters.Errors,
+		Throttled:      counters.Throttled,
+		AuthFailures:   counters.AuthFailures,
+		UpstreamErrors: counters.UpstreamErrors,
+		Messages:       counters.Messages,
+		Heartbeats:     counters.Heartbeats,
+		InFlight:       atomic.LoadInt64(&t.InFlight),
+
+		Queued:          atomic.LoadInt64(&t.Queued),
+		ThrottleDropped: counters.ThrottleDropped,
+
+		KeepAliveIntervalSec: t.KeepAliveIntervalSec,
+		KeepAliveFile:        t.KeepAliveFile,
+	}
+	if next := keepAliveNextFire(t); !next.IsZero() {
+		st.KeepAliveNextFire = &next
+	}
+	return st
+}
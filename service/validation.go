@@ -0,0 +1,468 @@
+package service
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"tcp_sandbox/domain"
+)
+
+// -----------------------------------------------------------
+// Config Validation
+// -----------------------------------------------------------
+
+// tenantIDPattern is what a tenant ID may look like.
+var tenantIDPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// ConfigError rejects a tenants config; it carries the full validation report.
+type ConfigError struct {
+	Report domain.ValidationReport
+}
+
+func (e *ConfigError) Error() string {
+	msgs := make([]string, 0, len(e.Report.Errors))
+	for _, is := range e.Report.Errors {
+		msgs = append(msgs, FormatIssue(is))
+	}
+	return fmt.Sprintf("invalid tenants config (%d error(s)): %s", len(e.Report.Errors), strings.Join(msgs, "; "))
+}
+
+// FormatIssue renders an issue for logs and the CLI, e.g. `tenant "A" (id tenant-a) StartByte: ...`.
+func FormatIssue(is domain.ValidationIssue) string {
+	s := fmt.Sprintf("tenant %q", is.Tenant)
+	if is.ID != "" {
+		s += fmt.Sprintf(" (id %s)", is.ID)
+	}
+	if is.Field != "" {
+		s += " " + is.Field
+	}
+	return s + ": " + is.Message
+}
+
+// ValidateTenantsConfig checks the content of a tenants file without applying it.
+func ValidateTenantsConfig(data []byte) domain.ValidationReport {
+	_, report, _ := parseTenantsConfig(data)
+	return report
+}
+
+// parseTenantsConfig decodes a tenants file, migrates tenants of older formats, resolves secret
+// references and validates every tenant. It also returns the number of migrated tenants.
+// The tenants are only usable if the report is valid.
+func parseTenantsConfig(data []byte) ([]domain.Tenant, domain.ValidationReport, int) {
+	v := &validator{}
+
+	var fileTenants []domain.Tenant
+	if err := json.Unmarshal(data, &fileTenants); err != nil {
+		v.errorf("", "", "", "json unmarshal error: %v", err)
+		return nil, v.report(), 0
+	}
+	v.unknownFields(data)
+
+	names := make([]string, len(fileTenants))
+	for i := range fileTenants {
+		names[i] = fileTenants[i].Name
+		if names[i] == "" {
+			names[i] = fmt.Sprintf("tenants[%d]", i)
+		}
+	}
+	migrated := 0
+	for i, note := range migrateTenants(fileTenants) {
+		if note != "" {
+			migrated++
+			v.warnf(names[i], fileTenants[i].ID, "", "%s", note)
+		}
+	}
+
+	tenants := make([]*domain.Tenant, len(fileTenants))
+	for i := range fileTenants {
+		tenants[i] = &fileTenants[i]
+		if err := ResolveSecretRefs(tenants[i]); err != nil {
+			v.errorf(names[i], tenants[i].ID, "", "resolving secret references: %v", err)
+		}
+	}
+	v.tenants(tenants, names)
+	return fileTenants, v.report(), migrated
+}
+
+// ValidateTenants checks tenants with resolved secrets, e.g. the tenants as a patch would leave
+// them.
+func ValidateTenants(tenants []*domain.Tenant) domain.ValidationReport {
+	v := &validator{}
+	names := make([]string, len(tenants))
+	for i, t := range tenants {
+		names[i] = t.Name
+		if names[i] == "" {
+			names[i] = t.ID
+		}
+	}
+	v.tenants(tenants, names)
+	return v.report()
+}
+
+// tenants checks every tenant and what they must not share (IDs, listen addresses).
+func (v *validator) tenants(tenants []*domain.Tenant, names []string) {
+	ids := make(map[string]string)
+	bound := make(map[string][]boundAddress)
+	limits := make(map[string]endpointLimit)
+	for i, t := range tenants {
+		name := names[i]
+		if other, ok := ids[t.ID]; ok && t.ID != "" {
+			v.errorf(name, t.ID, "ID", "duplicate ID, already used by tenant %q", other)
+		}
+		ids[t.ID] = name
+		v.listenerConflicts(name, t, bound)
+		v.rateLimitConflicts(name, t, limits)
+		v.tenant(name, t)
+	}
+}
+
+// boundAddress is a listen address already taken by a tenant, by port.
+type boundAddress struct {
+	host   string
+	tenant string
+}
+
+// listenerConflicts reports listen addresses that cannot be bound next to those of the tenants
+// checked before: the same address, or the same port where one side listens on all interfaces.
+func (v *validator) listenerConflicts(name string, t *domain.Tenant, bound map[string][]boundAddress) {
+	for i, l := range t.Listeners {
+		host, port, err := net.SplitHostPort(l.Address)
+		if err != nil {
+			continue // reported with the tenant's fields
+		}
+		for _, b := range bound[port] {
+			if b.host == host || isWildcardHost(b.host) || isWildcardHost(host) {
+				v.errorf(name, t.ID, fmt.Sprintf("Listeners[%d].Address", i), "%s overlaps %s of tenant %q",
+					l.Address, net.JoinHostPort(b.host, port), b.tenant)
+				break
+			}
+		}
+		bound[port] = append(bound[port], boundAddress{host: host, tenant: name})
+	}
+}
+
+// endpointLimit is the rate limit the first tenant posting to an endpoint configures.
+type endpointLimit struct {
+	limit  string
+	tenant string
+}
+
+// rateLimitConflicts warns when tenants sharing an endpoint configure different rate limits;
+// the endpoint has one bucket, limited by the strictest of them.
+func (v *validator) rateLimitConflicts(name string, t *domain.Tenant, limits map[string]endpointLimit) {
+	if t.Endpoint == "" {
+		return
+	}
+	limit := fmt.Sprintf("RateLimitPerSec %g, RateLimitBurst %d", t.RateLimitPerSec, t.RateLimitBurst)
+	first, ok := limits[t.Endpoint]
+	if !ok {
+		limits[t.Endpoint] = endpointLimit{limit: limit, tenant: name}
+	} else if first.limit != limit {
+		v.warnf(name, t.ID, "RateLimitPerSec", "%s differs from %s of tenant %q on the same endpoint; the strictest limit applies to both",
+			limit, first.limit, first.tenant)
+	}
+}
+
+func isWildcardHost(host string) bool {
+	return host == "" || host == "0.0.0.0" || host == "::"
+}
+
+type validator struct {
+	errors   []domain.ValidationIssue
+	warnings []domain.ValidationIssue
+}
+
+func (v *validator) errorf(tenant, id, field, format string, args ...interface{}) {
+	v.errors = append(v.errors, domain.ValidationIssue{Tenant: tenant, ID: id, Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+func (v *validator) warnf(tenant, id, field, format string, args ...interface{}) {
+	v.warnings = append(v.warnings, domain.ValidationIssue{Tenant: tenant, ID: id, Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+func (v *validator) report() domain.ValidationReport {
+	return domain.ValidationReport{Valid: len(v.errors) == 0, Errors: v.errors, Warnings: v.warnings}
+}
+
+// tenant checks the fields of one tenant.
+func (v *validator) tenant(name string, t *domain.Tenant) {
+	id := t.ID
+	errorf := func(field, format string, args ...interface{}) { v.errorf(name, id, field, format, args...) }
+	warnf := func(field, format string, args ...interface{}) { v.warnf(name, id, field, format, args...) }
+
+	if t.ID == "" {
+		errorf("ID", "must not be empty")
+	} else if !tenantIDPattern.MatchString(t.ID) {
+		errorf("ID", "%q may only contain letters, digits, '.', '_' and '-'", t.ID)
+	}
+	if t.Name == "" {
+		errorf("Name", "must not be empty")
+	}
+	if t.StartByte == t.EndByte {
+		errorf("EndByte", "must differ from StartByte (both %d)", t.StartByte)
+	}
+
+	// Listening
+	switch {
+	case t.Port != "":
+		errorf("Port", "cannot be combined with Listeners, add %q to Listeners instead", t.Port)
+	case t.BindAddress != "":
+		errorf("BindAddress", "set without Port")
+	case len(t.Listeners) == 0:
+		errorf("Listeners", "at least one listener is required")
+	}
+	for i, l := range t.Listeners {
+		v.listener(fmt.Sprintf("Listeners[%d]", i), t, l, errorf)
+	}
+	if t.ListenerOverlapSec < 0 {
+		errorf("ListenerOverlapSec", "must not be negative")
+	}
+	if !oneOf(t.FramingChange, "", "new-connections", "next-frame") {
+		errorf("FramingChange", "unknown mode %q (new-connections or next-frame)", t.FramingChange)
+	}
+
+	// Upstream
+	if t.Endpoint == "" {
+		warnf("Endpoint", "not set, received messages cannot be forwarded")
+	} else if u, err := url.Parse(t.Endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errorf("Endpoint", "%q is not an http(s) URL", t.Endpoint)
+	}
+	if !oneOf(t.MessageFormat, "", "json", "xml", "text") {
+		errorf("MessageFormat", "unknown format %q (json, xml or text)", t.MessageFormat)
+	}
+	if t.RateLimitPerSec < 0 {
+		errorf("RateLimitPerSec", "must not be negative")
+	}
+	if t.RateLimitBurst < 0 {
+		errorf("RateLimitBurst", "must not be negative")
+	}
+	v.auth(t, errorf, warnf)
+
+	// Keep-alive
+	if !oneOf(t.KeepAliveFormat, "", "xml", "json", "text", "hex") {
+		errorf("KeepAliveFormat", "unknown format %q (xml, json, text or hex)", t.KeepAliveFormat)
+	}
+	if !oneOf(t.KeepAliveMode, "", "tenant", "idle") {
+		errorf("KeepAliveMode", "unknown mode %q (tenant or idle)", t.KeepAliveMode)
+	}
+	for _, f := range []struct {
+		field string
+		n     int
+	}{
+		{"KeepAliveIntervalSec", t.KeepAliveIntervalSec},
+		{"KeepAliveJitterSec", t.KeepAliveJitterSec},
+		{"KeepAliveReplyTimeoutSec", t.KeepAliveReplyTimeoutSec},
+		{"KeepAliveMaxMissed", t.KeepAliveMaxMissed},
+		{"WriteTimeoutSec", t.WriteTimeoutSec},
+		{"OutboundQueueSize", t.OutboundQueueSize},
+		{"DrainTimeoutSec", t.DrainTimeoutSec},
+	} {
+		if f.n < 0 {
+			errorf(f.field, "must not be negative")
+		}
+	}
+	if t.KeepAliveIntervalSec > 0 && t.KeepAliveFile == "" && t.KeepAliveMessage == "" {
+		warnf("KeepAliveIntervalSec", "set without KeepAliveFile or KeepAliveMessage, no keep-alive is sent")
+	}
+	if t.KeepAliveFile != "" && t.KeepAliveMessage == "" {
+		if _, err := os.Stat(t.KeepAliveFile); err != nil {
+			if oneOf(strings.ToLower(t.KeepAliveFormat), "text", "hex") {
+				warnf("KeepAliveFile", "%v (no keep-alive is sent until it exists)", err)
+			} else {
+				warnf("KeepAliveFile", "%v (a default keep-alive is used)", err)
+			}
+		}
+	}
+	if strings.EqualFold(t.KeepAliveFormat, "hex") && t.KeepAliveMessage != "" {
+		if _, err := decodeHexPayload(t.KeepAliveMessage); err != nil {
+			errorf("KeepAliveMessage", "%v", err)
+		}
+	}
+	for _, f := range []struct{ field, expr string }{
+		{"KeepAliveReplyPattern", t.KeepAliveReplyPattern},
+		{"HeartbeatPattern", t.HeartbeatPattern},
+	} {
+		if f.expr == "" {
+			continue
+		}
+		if _, err := compileFramePattern(f.expr); err != nil {
+			errorf(f.field, "invalid regular expression: %v", err)
+		}
+	}
+}
+
+// listener checks one listen endpoint of a tenant.
+func (v *validator) listener(field string, t *domain.Tenant, l domain.ListenerConfig, errorf func(field, format string, args ...interface{})) {
+	if host, port, err := net.SplitHostPort(l.Address); err != nil {
+		errorf(field+".Address", "%v", err)
+	} else if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		errorf(field+".Address", "%q is not a port number (1-65535)", port)
+	} else if strings.Contains(host, ":") && net.ParseIP(host) == nil {
+		errorf(field+".Address", "%q is not an IPv6 address", host)
+	}
+
+	if (l.TLSCertFile == "") != (l.TLSKeyFile == "") {
+		errorf(field+".TLSCertFile", "TLSCertFile and TLSKeyFile must be set together")
+	} else if l.TLSCertFile != "" {
+		if _, err := tls.LoadX509KeyPair(l.TLSCertFile, l.TLSKeyFile); err != nil {
+			errorf(field+".TLSCertFile", "%v", err)
+		}
+	}
+
+	if l.StartByte != 0 || l.EndByte != 0 {
+		start, end := t.StartByte, t.EndByte
+		if l.StartByte != 0 {
+			start = l.StartByte
+		}
+		if l.EndByte != 0 {
+			end = l.EndByte
+		}
+		if start == end {
+			errorf(field+".EndByte", "must differ from StartByte (both %d)", start)
+		}
+	}
+}
+
+// auth checks the credentials required by the tenant's upstream auth type.
+func (v *validator) auth(t *domain.Tenant, errorf, warnf func(field, format string, args ...interface{})) {
+	c := t.OAuthCredentials
+	switch strings.ToLower(t.AuthType) {
+	case "", "oauth":
+		if t.AuthType == "" && t.SimpleAuthToken != "" {
+			return
+		}
+		if t.AuthType == "" && t.Endpoint == "" {
+			return
+		}
+		report := errorf
+		if t.AuthType == "" {
+			report = warnf // legacy default, may just be unconfigured
+		}
+		if c.TokenURL == "" {
+			report("OAuthCredentials.TokenURL", "required for OAuth")
+		} else if u, err := url.Parse(c.TokenURL); err != nil || u.Scheme == "" || u.Host == "" {
+			report("OAuthCredentials.TokenURL", "%q is not a URL", c.TokenURL)
+		}
+		if c.ClientID == "" {
+			report("OAuthCredentials.ClientID", "required for OAuth")
+		}
+		if !oneOf(c.GrantType, "", "client_credentials", "password", "refresh_token") {
+			errorf("OAuthCredentials.GrantType", "unsupported grant type %q", c.GrantType)
+		}
+		if !oneOf(c.AuthMethod, "", "client_secret_post", "client_secret_basic", "private_key_jwt", "none") {
+			errorf("OAuthCredentials.AuthMethod", "unsupported client auth method %q", c.AuthMethod)
+		}
+		if strings.EqualFold(c.AuthMethod, "private_key_jwt") && c.PrivateKeyFile == "" {
+			errorf("OAuthCredentials.PrivateKeyFile", "required for private_key_jwt")
+		}
+		if strings.EqualFold(c.GrantType, "refresh_token") && c.RefreshToken == "" {
+			errorf("OAuthCredentials.RefreshToken", "required for the refresh_token grant")
+		}
+	case "none":
+	case "simple":
+		if t.SimpleAuthToken == "" {
+			errorf("SimpleAuthToken", "required for auth type simple")
+		}
+	case "basic":
+		if t.BasicAuth == nil || t.BasicAuth.Username == "" {
+			errorf("BasicAuth.Username", "required for auth type basic")
+		}
+	case "apikey":
+		if t.APIKey == nil || t.APIKey.Name == "" {
+			errorf("APIKey.Name", "required for auth type apikey")
+		} else if !oneOf(t.APIKey.In, "", "header", "query") {
+			errorf("APIKey.In", "must be header or query, not %q", t.APIKey.In)
+		}
+	case "bearer":
+		if t.BearerToken == "" {
+			errorf("BearerToken", "required for auth type bearer")
+		}
+	default:
+		errorf("AuthType", "unknown auth type %q (none, simple, oauth, basic, apikey or bearer)", t.AuthType)
+	}
+}
+
+// unknownFields warns about keys the config type does not have, usually typos, and about
+// runtime counters written into the file by older versions.
+func (v *validator) unknownFields(data []byte) {
+	var raw []map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return
+	}
+	known := jsonFieldNames(reflect.TypeOf(domain.TenantConfig{}))
+	knownListener := jsonFieldNames(reflect.TypeOf(domain.ListenerConfig{}))
+	counters := jsonFieldNames(reflect.TypeOf(domain.TenantCounters{}))
+	for i, obj := range raw {
+		name := fmt.Sprintf("tenants[%d]", i)
+		var n string
+		if json.Unmarshal(obj["Name"], &n) == nil && n != "" {
+			name = n
+		}
+		var id string
+		json.Unmarshal(obj["ID"], &id)
+		for _, key := range sortedKeys(obj) {
+			switch k := strings.ToLower(key); {
+			case known[k]:
+			case counters[k]:
+				v.warnf(name, id, key, "runtime counter, ignored (counters are not config, see the state file)")
+			default:
+				v.warnf(name, id, key, "unknown field, ignored")
+			}
+		}
+
+		var listeners []map[string]json.RawMessage
+		json.Unmarshal(obj["Listeners"], &listeners)
+		for j, l := range listeners {
+			for _, key := range sortedKeys(l) {
+				if !knownListener[strings.ToLower(key)] {
+					v.warnf(name, id, fmt.Sprintf("Listeners[%d].%s", j, key), "unknown field, ignored")
+				}
+			}
+		}
+	}
+}
+
+func sortedKeys(obj map[string]json.RawMessage) []string {
+	keys := make([]string, 0, len(obj))
+	for key := range obj {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// jsonFieldNames returns the lowercased JSON names of a struct's fields
+// (encoding/json matches keys case-insensitively).
+func jsonFieldNames(typ reflect.Type) map[string]bool {
+	names := make(map[string]bool)
+	for i := 0; i < typ.NumField(); i++ {
+		f := typ.Field(i)
+		tag := strings.Split(f.Tag.Get("json"), ",")[0]
+		switch {
+		case tag == "-":
+		case tag != "":
+			names[strings.ToLower(tag)] = true
+		default:
+			names[strings.ToLower(f.Name)] = true
+		}
+	}
+	return names
+}
+
+func oneOf(v string, allowed ...string) bool {
+	for _, a := range allowed {
+		if strings.EqualFold(v, a) {
+			return true
+		}
+	}
+	return false
+}
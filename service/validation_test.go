@@ -0,0 +1,159 @@
+package service
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"tcp_sandbox/domain"
+)
+
+// validTenant is a tenant without errors or warnings; cases replace or add fields with extra,
+// a list of JSON members.
+func validTenant(id, addr, extra string) string {
+	if extra != "" {
+		extra = "," + extra
+	}
+	return `{"ID":"` + id + `","Name":"` + strings.ToUpper(id) + `","StartByte":2,"EndByte":3,` +
+		`"Listeners":[{"Address":"` + addr + `"}],"Endpoint":"http://upstream.invalid/in","AuthType":"none"` + extra + `}`
+}
+
+// issueFields lists issues as "tenant field", for comparison.
+func issueFields(issues []domain.ValidationIssue) []string {
+	out := []string{}
+	for _, is := range issues {
+		out = append(out, is.Tenant+" "+is.Field)
+	}
+	return out
+}
+
+func TestValidateTenantsConfig(t *testing.T) {
+	t.Setenv("TCP_SANDBOX_TEST_TOKEN", "t0ken")
+	for _, tc := range []struct {
+		name             string
+		config           string
+		errors, warnings []string
+	}{
+		{name: "valid", config: "[" + validTenant("a", ":3000", "") + "," + validTenant("b", ":3001", "") + "]"},
+		{name: "not JSON", config: `{"ID":`, errors: []string{" "}},
+
+		// IDs and framing
+		{name: "invalid ID", config: "[" + validTenant("a b", ":3000", "") + "]", errors: []string{"A B ID"}},
+		{
+			name:   "duplicate ID",
+			config: "[" + validTenant("a", ":3000", "") + "," + validTenant("a", ":3001", `"Name":"Other"`) + "]",
+			errors: []string{"Other ID"},
+		},
+		{name: "same start and end byte", config: "[" + validTenant("a", ":3000", `"EndByte":2`) + "]", errors: []string{"A EndByte"}},
+
+		// listeners
+		{name: "no listeners", config: `[{"ID":"a","Name":"A","StartByte":2,"EndByte":3,"Listeners":[],"AuthType":"none","Endpoint":"http://x/"}]`, errors: []string{"A Listeners"}},
+		{name: "Port next to Listeners", config: "[" + validTenant("a", ":3000", `"Port":"3001"`) + "]", errors: []string{"A Port"}},
+		{name: "no port", config: "[" + validTenant("a", "localhost", "") + "]", errors: []string{"A Listeners[0].Address"}},
+		{name: "port out of range", config: "[" + validTenant("a", ":70000", "") + "]", errors: []string{"A Listeners[0].Address"}},
+		{name: "port 0", config: "[" + validTenant("a", "127.0.0.1:0", "") + "]", errors: []string{"A Listeners[0].Address"}},
+		{
+			name:   "certificate without key",
+			config: `[{"ID":"a","Name":"A","StartByte":2,"EndByte":3,"Listeners":[{"Address":":3000","TLSCertFile":"c.pem"}],"AuthType":"none","Endpoint":"http://x/"}]`,
+			errors: []string{"A Listeners[0].TLSCertFile"},
+		},
+		{
+			name:   "listener framing override equal",
+			config: `[{"ID":"a","Name":"A","StartByte":2,"EndByte":3,"Listeners":[{"Address":":3000","EndByte":2}],"AuthType":"none","Endpoint":"http://x/"}]`,
+			errors: []string{"A Listeners[0].EndByte"},
+		},
+		{
+			name:   "same address in two tenants",
+			config: "[" + validTenant("a", "127.0.0.1:3000", "") + "," + validTenant("b", "127.0.0.1:3000", "") + "]",
+			errors: []string{"B Listeners[0].Address"},
+		},
+		{
+			name:   "wildcard overlaps a specific address",
+			config: "[" + validTenant("a", "127.0.0.1:3000", "") + "," + validTenant("b", ":3000", "") + "]",
+			errors: []string{"B Listeners[0].Address"},
+		},
+		{
+			name:   "same port on different addresses",
+			config: "[" + validTenant("a", "127.0.0.1:3000", "") + "," + validTenant("b", "127.0.0.2:3000", "") + "]",
+		},
+		{name: "unknown framing change", config: "[" + validTenant("a", ":3000", `"FramingChange":"later"`) + "]", errors: []string{"A FramingChange"}},
+
+		// upstream
+		{name: "no endpoint", config: "[" + validTenant("a", ":3000", `"Endpoint":""`) + "]", warnings: []string{"A Endpoint"}},
+		{name: "endpoint not http", config: "[" + validTenant("a", ":3000", `"Endpoint":"ftp://x/in"`) + "]", errors: []string{"A Endpoint"}},
+		{name: "unknown auth type", config: "[" + validTenant("a", ":3000", `"AuthType":"kerberos"`) + "]", errors: []string{"A AuthType"}},
+		{
+			name:   "oauth without credentials",
+			config: "[" + validTenant("a", ":3000", `"AuthType":"oauth"`) + "]",
+			errors: []string{"A OAuthCredentials.TokenURL", "A OAuthCredentials.ClientID"},
+		},
+		{
+			name:     "legacy default auth without credentials",
+			config:   "[" + validTenant("a", ":3000", `"AuthType":""`) + "]",
+			warnings: []string{"A OAuthCredentials.TokenURL", "A OAuthCredentials.ClientID"},
+		},
+		{name: "basic without user", config: "[" + validTenant("a", ":3000", `"AuthType":"basic"`) + "]", errors: []string{"A BasicAuth.Username"}},
+		{name: "resolvable secret reference", config: "[" + validTenant("a", ":3000", `"AuthType":"bearer","BearerToken":"${env:TCP_SANDBOX_TEST_TOKEN}"`) + "]"},
+		{
+			name:   "unresolvable secret reference",
+			config: "[" + validTenant("a", ":3000", `"AuthType":"bearer","BearerToken":"${env:TCP_SANDBOX_TEST_MISSING}"`) + "]",
+			errors: []string{"A "},
+		},
+		{name: "negative rate limit", config: "[" + validTenant("a", ":3000", `"RateLimitPerSec":-1`) + "]", errors: []string{"A RateLimitPerSec"}},
+		{
+			name:     "different rate limits on one endpoint",
+			config:   "[" + validTenant("a", ":3000", `"RateLimitPerSec":5`) + "," + validTenant("b", ":3001", `"RateLimitPerSec":10`) + "]",
+			warnings: []string{"B RateLimitPerSec"},
+		},
+
+		// keep-alive
+		{name: "negative interval", config: "[" + validTenant("a", ":3000", `"KeepAliveIntervalSec":-5`) + "]", errors: []string{"A KeepAliveIntervalSec"}},
+		{name: "interval without message", config: "[" + validTenant("a", ":3000", `"KeepAliveIntervalSec":30`) + "]", warnings: []string{"A KeepAliveIntervalSec"}},
+		{name: "invalid hex keep-alive", config: "[" + validTenant("a", ":3000", `"KeepAliveFormat":"hex","KeepAliveMessage":"zz"`) + "]", errors: []string{"A KeepAliveMessage"}},
+		{name: "invalid pattern", config: "[" + validTenant("a", ":3000", `"HeartbeatPattern":"("`) + "]", errors: []string{"A HeartbeatPattern"}},
+
+		// fields of other versions, typos
+		{
+			name:     "unknown and counter fields",
+			config:   "[" + validTenant("a", ":3000", `"Endpiont":"x","Messages":3`) + "]",
+			warnings: []string{"A Endpiont", "A Messages"},
+		},
+		{
+			name:     "port-keyed tenant is migrated",
+			config:   `[{"Name":"Old","Port":"3000","StartByte":2,"EndByte":3,"Endpoint":"http://x/","AuthType":"none"}]`,
+			warnings: []string{"Old "},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			report := ValidateTenantsConfig([]byte(tc.config))
+			if tc.errors == nil {
+				tc.errors = []string{}
+			}
+			if tc.warnings == nil {
+				tc.warnings = []string{}
+			}
+			if got := issueFields(report.Errors); !reflect.DeepEqual(got, tc.errors) {
+				t.Errorf("errors %q, want %q\n%+v", got, tc.errors, report.Errors)
+			}
+			if got := issueFields(report.Warnings); !reflect.DeepEqual(got, tc.warnings) {
+				t.Errorf("warnings %q, want %q\n%+v", got, tc.warnings, report.Warnings)
+			}
+			if report.Valid != (len(tc.errors) == 0) {
+				t.Errorf("Valid = %v with %d error(s)", report.Valid, len(report.Errors))
+			}
+		})
+	}
+}
+
+func TestValidateTenantsChecksPatchedTenants(t *testing.T) {
+	tenant := func(id, addr string) *domain.Tenant {
+		return &domain.Tenant{TenantConfig: domain.TenantConfig{
+			ID: id, StartByte: 2, EndByte: 3, Listeners: listeners(addr), Endpoint: "http://x/", AuthType: "none",
+		}}
+	}
+	report := ValidateTenants([]*domain.Tenant{tenant("a", ":3000"), tenant("b", ":3000")})
+	// without a name, tenants are reported by ID
+	if got, want := issueFields(report.Errors), []string{"a Name", "b Listeners[0].Address", "b Name"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("errors %q, want %q", got, want)
+	}
+}